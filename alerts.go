@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const alertStateFileName = "ecobee-alert-state.json"
+
+// alertTracker remembers which alerts have already been written, so that
+// each alert is forwarded only once even though Ecobee returns it on every
+// poll until it's acknowledged.
+type alertTracker struct {
+	workDir string // empty if state is not persisted
+
+	// Seen maps each written alert's key to the time it was first seen.
+	Seen map[string]time.Time `json:"seen"`
+}
+
+// newAlertTracker returns an alertTracker whose state is persisted in
+// workDir. If workDir is empty, state is kept only in memory.
+func newAlertTracker(workDir string) (*alertTracker, error) {
+	a := &alertTracker{workDir: workDir, Seen: make(map[string]time.Time)}
+	if workDir == "" {
+		return a, nil
+	}
+	if err := loadState(workDir, alertStateFileName, a); err != nil {
+		return nil, err
+	}
+	if a.Seen == nil {
+		a.Seen = make(map[string]time.Time)
+	}
+	return a, nil
+}
+
+func (a *alertTracker) save() error {
+	if a.workDir == "" {
+		return nil
+	}
+	return saveState(a.workDir, alertStateFileName, a)
+}
+
+// alertKey identifies an alert. Alerts are keyed by acknowledgeRef, which
+// Ecobee assigns uniquely to each alert; the remaining fields are a fallback
+// for alerts without one.
+func alertKey(a ecobee.Alert) string {
+	if a.AcknowledgeRef != "" {
+		return a.AcknowledgeRef
+	}
+	return fmt.Sprintf("%d|%s %s|%s", a.AlertNumber, a.Date, a.Time, a.Text)
+}
+
+// processAlerts writes alerts which haven't been written before.
+func (c *connector) processAlerts(t *ecobee.Thermostat, now time.Time) error {
+	changed := false
+	current := make(map[string]bool, len(t.Alerts))
+	for _, alert := range t.Alerts {
+		key := alertKey(alert)
+		current[key] = true
+		if _, seen := c.alerts.Seen[key]; seen {
+			continue
+		}
+
+		alertTime, err := time.Parse("2006-01-02 15:04:05", alert.Date+" "+alert.Time)
+		if err != nil {
+			alertTime = now
+		} else {
+			alertTime = alertTime.Add(-thermostatUTCOffset(t))
+		}
+
+		fmt.Printf("Alert at %s:\n", alertTime)
+		fmt.Printf("\t%s (%d, %s): %s\n", alert.Severity, alert.AlertNumber, alert.NotificationType, alert.Text)
+
+		if err := c.out.write(
+			"ecobee_alert",
+			map[string]string{
+				thermostatNameTag:   t.Name,
+				"severity":          alert.Severity,
+				"alert_type":        alert.AlertType,
+				"notification_type": alert.NotificationType,
+			},
+			map[string]any{
+				"acknowledge_ref": alert.AcknowledgeRef,
+				"text":            alert.Text,
+				"alert_number":    alert.AlertNumber,
+				"acknowledgement": alert.Acknowledgement,
+				"reminder":        alert.Reminder,
+			},
+			alertTime,
+			"alert",
+		); err != nil {
+			return err
+		}
+		if err := c.out.publishJSON("alert", alert); err != nil {
+			return err
+		}
+		c.alerts.Seen[key] = now
+		changed = true
+	}
+
+	// Forget alerts which have been acknowledged or have otherwise gone away,
+	// so the state doesn't grow without bound. A response without an alerts
+	// section (as opposed to an empty one) says nothing about which alerts
+	// remain, so nothing is forgotten then.
+	if t.Alerts != nil {
+		for key := range c.alerts.Seen {
+			if !current[key] {
+				delete(c.alerts.Seen, key)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := c.alerts.save(); err != nil {
+		log.Printf("failed to save alert state: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const (
+	archiveDirName              = "response-archive"
+	defaultArchiveRetentionDays = 30
+)
+
+// responseArchive stores raw GetThermostat response bodies, gzipped, in one
+// directory per (UTC) day. Days older than the retention period are removed.
+type responseArchive struct {
+	dir       string
+	retention time.Duration
+}
+
+func newResponseArchive(config Config) *responseArchive {
+	days := config.ArchiveRetentionDays
+	if days == 0 {
+		days = defaultArchiveRetentionDays
+	}
+	return &responseArchive{
+		dir:       path.Join(config.WorkDir, archiveDirName),
+		retention: time.Duration(days) * 24 * time.Hour,
+	}
+}
+
+// save archives a response body received at the given time.
+func (a *responseArchive) save(body []byte, at time.Time) error {
+	at = at.UTC()
+	dayDir := path.Join(a.dir, at.Format("2006-01-02"))
+	if err := os.MkdirAll(dayDir, 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(
+		path.Join(dayDir, fmt.Sprintf("thermostat-%s.json.gz", at.Format("20060102T150405.000Z"))),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600,
+	)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(body); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return a.prune(at)
+}
+
+// prune removes day directories older than the retention period.
+func (a *responseArchive) prune(now time.Time) error {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return err
+	}
+	cutoff := now.Add(-a.retention).Truncate(24 * time.Hour)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", e.Name())
+		if err != nil {
+			continue // not one of ours
+		}
+		if day.Before(cutoff) {
+			if err := os.RemoveAll(path.Join(a.dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replayArchive feeds every archived response under dir through conn, in
+// the order they were recorded. Responses which can't be parsed are skipped.
+func replayArchive(dir string, conn *connector) error {
+	var files []string
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && (strings.HasSuffix(p, ".json.gz") || strings.HasSuffix(p, ".json")) {
+			files = append(files, p)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// File names begin with their timestamp, so they sort chronologically:
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+
+	replayed := 0
+	for _, f := range files {
+		body, err := readArchivedResponse(f)
+		if err != nil {
+			log.Printf("skipping %s: %s", f, err)
+			continue
+		}
+		t, err := ecobee.ParseThermostatResponse(body)
+		if err != nil {
+			log.Printf("skipping %s: %s", f, err)
+			continue
+		}
+		if err := conn.process(t); err != nil {
+			return fmt.Errorf("failed to process %s: %w", f, err)
+		}
+		replayed++
+	}
+	log.Printf("Replayed %d of %d archived responses from %s", replayed, len(files), dir)
+	return nil
+}
+
+func readArchivedResponse(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if !strings.HasSuffix(p, ".gz") {
+		return io.ReadAll(f)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const (
+	configStateFileName = "ecobee-config-state.json"
+
+	// configWatchInterval is how often the thermostat summary is checked for
+	// configuration changes. Ecobee asks that the summary be polled no more
+	// than every 3 minutes.
+	configWatchInterval = 3 * time.Minute
+)
+
+// configState is the last seen configuration of the thermostat.
+type configState struct {
+	ThermostatRevision string           `json:"thermostat_revision"`
+	Program            *ecobee.Program  `json:"program,omitempty"`
+	Settings           *ecobee.Settings `json:"settings,omitempty"`
+}
+
+// configWatcher records changes to the thermostat's program and settings.
+// It checks the thermostat's revision via the (cheap) summary endpoint, and
+// only fetches and compares the program and settings when it changes.
+type configWatcher struct {
+	client   *ecobee.Client
+	config   Config
+	out      *output
+	stateDir string // empty to keep state in memory
+	state    configState
+}
+
+func newConfigWatcher(client *ecobee.Client, config Config, out *output, stateDir string) (*configWatcher, error) {
+	w := &configWatcher{client: client, config: config, out: out, stateDir: stateDir}
+	if stateDir != "" {
+		if err := loadState(stateDir, configStateFileName, &w.state); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// run checks for changes every configWatchInterval until ctx is done.
+func (w *configWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+	for {
+		if err := w.check(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to check for configuration changes: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *configWatcher) check(ctx context.Context) error {
+	summary, err := w.client.GetThermostatSummaryContext(ctx, ecobee.Selection{
+		SelectionType:  "thermostats",
+		SelectionMatch: w.config.ThermostatID,
+	})
+	if err != nil {
+		return err
+	}
+	s, ok := summary[w.config.ThermostatID]
+	if !ok {
+		return fmt.Errorf("thermostat %s not found in summary", w.config.ThermostatID)
+	}
+	if s.ThermostatRevision == w.state.ThermostatRevision && w.state.Program != nil {
+		return nil
+	}
+
+	ts, err := w.client.GetThermostatsContext(ctx, ecobee.Selection{
+		SelectionType:   "thermostats",
+		SelectionMatch:  w.config.ThermostatID,
+		IncludeProgram:  true,
+		IncludeSettings: true,
+	})
+	if err != nil {
+		return err
+	}
+	if len(ts) != 1 {
+		return fmt.Errorf("expected 1 thermostat, got %d", len(ts))
+	}
+	t := ts[0]
+
+	if w.state.Program != nil && w.state.Settings != nil {
+		now := time.Now()
+		for _, c := range diffPrograms(*w.state.Program, t.Program) {
+			if err := recordConfigChange(w.out, t.Name, "program", c, s.ThermostatRevision, now); err != nil {
+				return err
+			}
+		}
+		for _, c := range diffSettings(*w.state.Settings, t.Settings) {
+			if err := recordConfigChange(w.out, t.Name, "settings", c, s.ThermostatRevision, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.state = configState{
+		ThermostatRevision: s.ThermostatRevision,
+		Program:            &t.Program,
+		Settings:           &t.Settings,
+	}
+	if w.stateDir != "" {
+		if err := saveState(w.stateDir, configStateFileName, w.state); err != nil {
+			log.Printf("failed to save configuration state: %s", err)
+		}
+	}
+	return nil
+}
+
+// diffSettings compares two versions of the thermostat's settings. The user
+// access code is masked.
+func diffSettings(from, to ecobee.Settings) []configChange {
+	fromFields, toFields := flattenJSON(from), flattenJSON(to)
+	if fromFields["userAccessCode"] != toFields["userAccessCode"] {
+		fromFields["userAccessCode"], toFields["userAccessCode"] = `"(old code)"`, `"(new code)"`
+	}
+	return diffFields("", fromFields, toFields)
+}
+
+// recordConfigChange writes a configuration change.
+func recordConfigChange(out *output, thermostatName, section string, c configChange, revision string, at time.Time) error {
+	change := "changed"
+	if c.Old == "" {
+		change = "added"
+	} else if c.New == "" {
+		change = "removed"
+	}
+	log.Printf("Configuration change in %s: %s", section, c)
+	fields := map[string]any{
+		"old_value":           c.Old,
+		"new_value":           c.New,
+		"change":              change,
+		"thermostat_revision": revision,
+	}
+	if err := out.write(
+		"ecobee_config_change",
+		map[string]string{
+			thermostatNameTag: thermostatName,
+			"section":         section,
+			"field":           c.Field,
+		},
+		fields,
+		at,
+		"config_change",
+	); err != nil {
+		return err
+	}
+	fields["section"] = section
+	fields["field"] = c.Field
+	return out.publishJSON("config_change", fields)
+}
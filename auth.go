@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const (
+	credCacheFileName      = "ecobee-cred-cache"
+	simCredCacheFileName   = "ecobee-sim-cred-cache"
+	authPendingFileName    = "ecobee-auth-pending"
+	simAuthPendingFileName = "ecobee-sim-auth-pending"
+	credCacheKeyEnvVar     = "ECOBEE_CRED_CACHE_KEY"
+)
+
+// pendingAuth is stored in the work directory between -auth-request and
+// -auth-complete.
+type pendingAuth struct {
+	Pin       string    `json:"pin"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// requestAuthorization requests a new PIN from Ecobee, prints it along with
+// instructions, and stores the corresponding authorization code at
+// pendingPath for a later call to completeAuthorization.
+func requestAuthorization(config Config, pendingPath string, ecobeeOpts []ecobee.ClientOption) error {
+	pinResponse, err := ecobee.Authorize(config.APIKey, ecobeeOpts...)
+	if err != nil {
+		return fmt.Errorf("failed to request authorization PIN: %w", err)
+	}
+
+	pending := pendingAuth{
+		Pin:       pinResponse.EcobeePin,
+		Code:      pinResponse.Code,
+		ExpiresAt: time.Now().Add(time.Duration(pinResponse.ExpiresIn) * time.Minute),
+	}
+	pendingBytes, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(pendingPath, pendingBytes, 0600); err != nil {
+		return fmt.Errorf("failed to write pending authorization to '%s': %w", pendingPath, err)
+	}
+
+	fmt.Printf("PIN: %s\n", pending.Pin)
+	fmt.Println()
+	fmt.Println("Go to https://www.ecobee.com/consumerportal/index.html, navigate to My Apps, click Add Application, and enter the PIN.")
+	if pinResponse.ExpiresIn > 0 {
+		fmt.Printf("The PIN expires at %s.\n", pending.ExpiresAt.Format(time.RFC1123))
+	}
+	fmt.Println("Then run this program with -auth-complete to finish authorization.")
+	return nil
+}
+
+// completeAuthorization exchanges the authorization code stored at
+// pendingPath by requestAuthorization for credentials, writing them to the
+// credential cache at credCachePath.
+func completeAuthorization(config Config, pendingPath, credCachePath string, ecobeeOpts []ecobee.ClientOption) error {
+	pendingBytes, err := os.ReadFile(pendingPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no pending authorization found at '%s'; run with -auth-request first", pendingPath)
+	} else if err != nil {
+		return fmt.Errorf("failed to read pending authorization from '%s': %w", pendingPath, err)
+	}
+	var pending pendingAuth
+	if err := json.Unmarshal(pendingBytes, &pending); err != nil {
+		return fmt.Errorf("failed to parse pending authorization from '%s': %w", pendingPath, err)
+	}
+	if !pending.ExpiresAt.IsZero() && time.Now().After(pending.ExpiresAt) {
+		return fmt.Errorf("pending authorization PIN %s expired at %s; run with -auth-request again",
+			pending.Pin, pending.ExpiresAt.Format(time.RFC1123))
+	}
+
+	if err := ecobee.SaveToken(config.APIKey, credCachePath, pending.Code, ecobeeOpts...); err != nil {
+		return fmt.Errorf("failed to complete authorization (was PIN %s added under My Apps?): %w", pending.Pin, err)
+	}
+	if err := os.Remove(pendingPath); err != nil {
+		return fmt.Errorf("authorization succeeded, but failed to remove '%s': %w", pendingPath, err)
+	}
+
+	fmt.Println("Authorization complete; credentials saved.")
+	return nil
+}
+
+// authRequiredMessage explains how to authorize the connector when it is
+// running without cached credentials and cannot prompt for input.
+func authRequiredMessage(credCachePath string) string {
+	return fmt.Sprintf("No cached Ecobee credentials found at '%s'. Authorize the connector before running it as a service:\n"+
+		"  1. Run with -auth-request to get a PIN.\n"+
+		"  2. Enter the PIN under My Apps at https://www.ecobee.com/consumerportal/index.html.\n"+
+		"  3. Run with -auth-complete to save credentials.\n"+
+		"Alternatively, run with -list-thermostats at an interactive terminal.", credCachePath)
+}
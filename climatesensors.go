@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// participatingSensors returns the IDs of the sensors which participate in
+// the current climate. Climates refer to sensors by "<sensor ID>:<capability
+// ID>", e.g. "rs:100:1". It returns nil if the program isn't available, or if
+// the current climate isn't one of the program's climates (e.g. during some
+// events), since participation is then unknown.
+func participatingSensors(t *ecobee.Thermostat) map[string]bool {
+	var current *ecobee.Climate
+	for i := range t.Program.Climates {
+		if t.Program.Climates[i].ClimateRef == t.Program.CurrentClimateRef {
+			current = &t.Program.Climates[i]
+		}
+	}
+	if current == nil {
+		return nil
+	}
+	participating := make(map[string]bool)
+	for _, sensor := range t.RemoteSensors {
+		for _, cs := range current.Sensors {
+			if strings.HasPrefix(cs.ID, sensor.ID+":") {
+				participating[sensor.ID] = true
+			}
+		}
+	}
+	return participating
+}
+
+// climateSensorRef returns the reference a climate uses for the sensor: its
+// ID followed by the ID of its temperature capability.
+func climateSensorRef(sensor ecobee.RemoteSensor) ecobee.RemoteSensor {
+	return ecobee.RemoteSensor{ID: sensor.ID + ":" + temperatureCapabilityID(sensor), Name: sensor.Name}
+}
+
+// setClimateSensors changes which sensors participate in the climate with
+// the given ref. Sensors may be given by name or ID. It returns the changes
+// made to the program; if dryRun is true, the changes are only returned.
+func setClimateSensors(client *ecobee.Client, thermostatID, climateRef string, sensors []string, dryRun bool) ([]configChange, error) {
+	if len(sensors) == 0 {
+		return nil, fmt.Errorf("at least one sensor must participate in a climate")
+	}
+	t, err := client.GetThermostat(thermostatID)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []ecobee.RemoteSensor
+	for _, want := range sensors {
+		sensor, err := findSensor(t, want)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, climateSensorRef(sensor))
+	}
+
+	program := t.Program
+	program.Climates = append([]ecobee.Climate(nil), t.Program.Climates...)
+	found := false
+	for i := range program.Climates {
+		if program.Climates[i].ClimateRef == climateRef {
+			program.Climates[i].Sensors = refs
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no climate with ref '%s'", climateRef)
+	}
+
+	changes := diffPrograms(t.Program, program)
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	if err := client.UpdateProgram(thermostatID, program); err != nil {
+		return nil, fmt.Errorf("failed to update program: %w", err)
+	}
+	return changes, nil
+}
+
+// setClimateSensorsCommand is the payload of the set_climate_sensors
+// command.
+type setClimateSensorsCommand struct {
+	ClimateRef string   `json:"climate_ref"`
+	Sensors    []string `json:"sensors"`
+}
+
+func setClimateSensorsHandler(client *ecobee.Client, thermostatID string) commandHandler {
+	return func(payload []byte) error {
+		var cmd setClimateSensorsCommand
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			return fmt.Errorf("invalid set_climate_sensors command: %w", err)
+		}
+		_, err := setClimateSensors(client, thermostatID, cmd.ClimateRef, cmd.Sensors, false)
+		return err
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// commandHandler carries out a command received via MQTT. payload is the
+// message body.
+type commandHandler func(payload []byte) error
+
+// commandResult is published to <command topic>/result after each command.
+type commandResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// mqttCommands dispatches messages published to
+// <topic_root>/<thermostat_id>/command/<name> to the handler registered for
+// <name>, then publishes the outcome to .../command/<name>/result.
+// Commands are carried out one at a time, in the order they arrive.
+type mqttCommands struct {
+	config Config
+	queue  chan queuedCommand
+
+	mu       sync.Mutex
+	handlers map[string]commandHandler
+}
+
+// queuedCommand is a command waiting to be carried out.
+type queuedCommand struct {
+	client  mqtt.Client
+	name    string
+	payload []byte
+}
+
+// commandQueueSize is how many commands may wait to be carried out. Commands
+// arriving when the queue is full are dropped.
+const commandQueueSize = 16
+
+func newMQTTCommands(config Config) *mqttCommands {
+	m := &mqttCommands{
+		config:   config,
+		queue:    make(chan queuedCommand, commandQueueSize),
+		handlers: make(map[string]commandHandler),
+	}
+	go m.work()
+	return m
+}
+
+// work carries out queued commands.
+func (m *mqttCommands) work() {
+	for c := range m.queue {
+		m.dispatch(c.client, c.name, c.payload)
+	}
+}
+
+func (m *mqttCommands) topicPrefix() string {
+	return fmt.Sprintf("%s/%s/command/", m.config.MQTT.TopicRoot, m.config.ThermostatID)
+}
+
+// handle registers the handler for the named command.
+func (m *mqttCommands) handle(name string, h commandHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[name] = h
+}
+
+// subscribe subscribes to command topics. It is used as the MQTT client's
+// OnConnect handler, so subscriptions are restored after reconnecting.
+func (m *mqttCommands) subscribe(client mqtt.Client) {
+	topic := m.topicPrefix() + "+"
+	token := client.Subscribe(topic, 1, func(client mqtt.Client, msg mqtt.Message) {
+		if msg.Retained() {
+			// A retained command was sent some time ago, and would otherwise
+			// be carried out again on every reconnect.
+			log.Printf("ignoring retained MQTT command on '%s'", msg.Topic())
+			return
+		}
+		// Commands call the Ecobee API, which may be slow; don't block the
+		// MQTT client's message handling while they run.
+		c := queuedCommand{client: client, name: strings.TrimPrefix(msg.Topic(), m.topicPrefix()), payload: msg.Payload()}
+		select {
+		case m.queue <- c:
+		default:
+			log.Printf("too many MQTT commands waiting; dropping '%s'", c.name)
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Printf("failed to subscribe to MQTT command topic '%s': %s", topic, token.Error())
+		return
+	}
+	log.Printf("Listening for commands on MQTT topic '%s'", topic)
+}
+
+func (m *mqttCommands) dispatch(client mqtt.Client, name string, payload []byte) {
+	m.mu.Lock()
+	h, ok := m.handlers[name]
+	m.mu.Unlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("unknown command '%s'", name)
+	} else {
+		err = h(payload)
+	}
+	result := commandResult{OK: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("MQTT command '%s' failed: %s", name, err)
+	} else {
+		log.Printf("MQTT command '%s' succeeded", name)
+	}
+	if err := publishJSONToMQTT(client, m.config, "command/"+name+"/result", result); err != nil {
+		log.Printf("failed to publish result of MQTT command '%s': %s", name, err)
+	}
+}
+
+// acknowledgeCommand is the payload of the acknowledge command. A payload
+// which isn't a JSON object is treated as the ack_ref of an alert to accept.
+type acknowledgeCommand struct {
+	AckRef        string `json:"ack_ref"`
+	AckType       string `json:"ack_type,omitempty"`
+	RemindMeLater bool   `json:"remind_me_later,omitempty"`
+}
+
+func acknowledgeHandler(client *ecobee.Client, thermostatID string) commandHandler {
+	return func(payload []byte) error {
+		cmd := acknowledgeCommand{AckType: ecobee.AckTypeAccept}
+		if p := strings.TrimSpace(string(payload)); strings.HasPrefix(p, "{") {
+			if err := json.Unmarshal([]byte(p), &cmd); err != nil {
+				return fmt.Errorf("invalid acknowledge command: %w", err)
+			}
+		} else {
+			cmd.AckRef = p
+		}
+		return client.Acknowledge(thermostatID, cmd.AckRef, cmd.AckType, cmd.RemindMeLater)
+	}
+}
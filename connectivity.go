@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// defaultStaleStatusMinutes is how long the thermostat's status may go
+// without updating before it's considered stale, unless configured
+// otherwise.
+const defaultStaleStatusMinutes = 30
+
+// processConnectivity writes the thermostat's connection state to the
+// ecobee_connectivity measurement, and flags when its status hasn't been
+// updated recently, which usually means it has lost its Wi-Fi connection
+// even if Ecobee still reports it as connected.
+func (c *connector) processConnectivity(t *ecobee.Thermostat, now time.Time) error {
+	r := t.Runtime
+	fields := map[string]any{
+		"connected": r.Connected,
+	}
+	connectedAt, connectedErr := time.Parse("2006-01-02 15:04:05", r.ConnectDateTime)
+	disconnectedAt, disconnectedErr := time.Parse("2006-01-02 15:04:05", r.DisconnectDateTime)
+	if connectedErr == nil {
+		fields["connect_time"] = connectedAt.Format(time.RFC3339)
+		if r.Connected {
+			fields["connected_minutes"] = now.Sub(connectedAt).Minutes()
+		}
+	}
+	if disconnectedErr == nil {
+		fields["disconnect_time"] = disconnectedAt.Format(time.RFC3339)
+		if !r.Connected {
+			fields["disconnected_minutes"] = now.Sub(disconnectedAt).Minutes()
+		} else if connectedErr == nil && connectedAt.After(disconnectedAt) {
+			fields["last_disconnect_minutes"] = connectedAt.Sub(disconnectedAt).Minutes()
+		}
+	}
+	if firstConnected, err := time.Parse("2006-01-02 15:04:05", r.FirstConnected); err == nil {
+		fields["first_connected"] = firstConnected.Format(time.RFC3339)
+	}
+
+	staleAfter := time.Duration(c.config.StaleStatusMinutes) * time.Minute
+	if staleAfter == 0 {
+		staleAfter = defaultStaleStatusMinutes * time.Minute
+	}
+	stale := false
+	if statusAt, err := time.Parse("2006-01-02 15:04:05", r.LastStatusModified); err == nil {
+		age := now.Sub(statusAt)
+		stale = age > staleAfter
+		fields["status_age_minutes"] = age.Minutes()
+		fields["status_stale"] = stale
+	}
+	if stale != c.statusStale {
+		if stale {
+			log.Printf("thermostat '%s' hasn't updated its status since %s; it may be offline", t.Name, r.LastStatusModified)
+		} else {
+			log.Printf("thermostat '%s' is updating its status again", t.Name)
+		}
+		c.statusStale = stale
+	}
+
+	fmt.Printf("Connectivity at %s:\n", now)
+	for _, k := range sortedKeys(fields) {
+		fmt.Printf("\t%s: %v\n", k, fields[k])
+	}
+	return c.out.write(
+		"ecobee_connectivity",
+		map[string]string{thermostatNameTag: t.Name},
+		fields,
+		now,
+		"connectivity",
+	)
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	wx "github.com/cdzombak/libwx"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// connector converts thermostat data into points, and writes them to the
+// configured outputs. It remembers what it has already written, so that
+// repeated data is not rewritten on every poll.
+type connector struct {
+	config    Config
+	out       *output
+	stateDir  string // where state is persisted; empty to keep it in memory
+	alerts    *alertTracker
+	events    *eventState
+	occupancy *occupancyState
+
+	lastWrittenRuntimeInterval int
+	lastWrittenWeather         time.Time
+	lastWrittenSensors         time.Time
+	lastWrittenSettings        ecobee.Settings
+	lastWrittenSettingsAt      time.Time
+	lastWrittenClimates        map[string]writtenClimate // by climate ref
+	lastWrittenClimatesAt      time.Time
+	statusStale                bool // whether the thermostat's status was stale at the last poll
+
+	nameMu sync.Mutex
+	name   string // the thermostat's name, as of the last poll
+}
+
+// settingsRewriteInterval is how often settings and climates are written even
+// when they haven't changed, so that recent data is always available to queries.
+const settingsRewriteInterval = time.Hour
+
+// newConnector returns a connector writing to out. State which must survive
+// restarts is persisted in stateDir, or only kept in memory if stateDir is
+// empty.
+func newConnector(config Config, out *output, stateDir string) (*connector, error) {
+	alerts, err := newAlertTracker(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	events := &eventState{}
+	if stateDir != "" {
+		if err := loadState(stateDir, eventStateFileName, events); err != nil {
+			return nil, err
+		}
+	}
+	occupancy, err := newOccupancyState(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{
+		config:    config,
+		out:       out,
+		stateDir:  stateDir,
+		alerts:    alerts,
+		events:    events,
+		occupancy: occupancy,
+	}, nil
+}
+
+// thermostatName returns the thermostat's name as of the last poll, or
+// fallback if there hasn't been one yet. It's safe to call concurrently with
+// process.
+func (c *connector) thermostatName(fallback string) string {
+	c.nameMu.Lock()
+	defer c.nameMu.Unlock()
+	if c.name == "" {
+		return fallback
+	}
+	return c.name
+}
+
+// process writes the data from a single GetThermostat response.
+func (c *connector) process(t *ecobee.Thermostat) error {
+	c.nameMu.Lock()
+	c.name = t.Name
+	c.nameMu.Unlock()
+
+	// Air-quality-related values are only in the current runtime,
+	// thus they need to be handled outside the extended runtime section
+	currentRuntimeReportTime, err := time.Parse("2006-01-02 15:04:05", t.Runtime.LastStatusModified)
+	if err != nil {
+		return err
+	}
+
+	actualAQAccuracy := t.Runtime.ActualAQAccuracy
+	actualAQScore := t.Runtime.ActualAQScore
+	actualCO2 := t.Runtime.ActualCO2
+	actualVOC := t.Runtime.ActualVOC
+
+	fmt.Printf("Air quality at %s:\n", currentRuntimeReportTime)
+	fmt.Printf("\tcurrent co2: %d\n\tcurrent voc: %d\n",
+		actualCO2, actualVOC)
+
+	if err := c.out.write(
+		"ecobee_air_quality",
+		map[string]string{thermostatNameTag: t.Name}, // tags
+		map[string]any{
+			"airquality_accuracy": actualAQAccuracy,
+			"airquality_score":    actualAQScore,
+			"co2":                 actualCO2,
+			"voc":                 actualVOC,
+		},
+		currentRuntimeReportTime,
+		"sensor",
+	); err != nil {
+		return err
+	}
+
+	latestRuntimeInterval := t.ExtendedRuntime.RuntimeInterval
+	log.Printf("latest runtime interval available is %d\n", latestRuntimeInterval)
+
+	// In the absence of a time zone indicator, Parse returns a time in UTC.
+	baseReportTime, err := time.Parse("2006-01-02 15:04:05", t.ExtendedRuntime.LastReadingTimestamp)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		reportTime := baseReportTime
+		if i == 0 {
+			reportTime = reportTime.Add(-5 * time.Minute)
+		}
+		if i == 2 {
+			reportTime = reportTime.Add(5 * time.Minute)
+		}
+
+		currentTemp := wx.TempF(float64(t.ExtendedRuntime.ActualTemperature[i]) / 10.0)
+		currentHumidity := t.ExtendedRuntime.ActualHumidity[i]
+		heatSetPoint := wx.TempF(float64(t.ExtendedRuntime.DesiredHeat[i]) / 10.0)
+		coolSetPoint := wx.TempF(float64(t.ExtendedRuntime.DesiredCool[i]) / 10.0)
+		humiditySetPoint := t.ExtendedRuntime.DesiredHumidity[i]
+		dehumiditySetPoint := t.ExtendedRuntime.DesiredDehumidity[i]
+		demandMgmtOffset := wx.TempF(float64(t.ExtendedRuntime.DmOffset[i]) / 10.0)
+		hvacMode := t.ExtendedRuntime.HvacMode[i]
+		heatPump1RunSec := t.ExtendedRuntime.HeatPump1[i]
+		heatPump2RunSec := t.ExtendedRuntime.HeatPump2[i]
+		auxHeat1RunSec := t.ExtendedRuntime.AuxHeat1[i]
+		auxHeat2RunSec := t.ExtendedRuntime.AuxHeat2[i]
+		auxHeat3RunSec := t.ExtendedRuntime.AuxHeat3[i]
+		cool1RunSec := t.ExtendedRuntime.Cool1[i]
+		cool2RunSec := t.ExtendedRuntime.Cool2[i]
+		fanRunSec := t.ExtendedRuntime.Fan[i]
+		humidifierRunSec := t.ExtendedRuntime.Humidifier[i]
+		dehumidifierRunSec := t.ExtendedRuntime.Dehumidifier[i]
+		economizerRunSec := t.ExtendedRuntime.Economizer[i]
+		ventilatorRunSec := t.ExtendedRuntime.Ventilator[i]
+
+		fmt.Printf("Thermostat conditions at %s:\n", reportTime)
+		fmt.Printf("\tcurrent temperature: %.1f degF (%.1f degC)\n\theat set point: %.1f degF (%.1f degC)"+
+			"\n\tcool set point: %.1f degF (%.1f degC)\n\tdemand management offset: %.1f (%.1f degC)\n",
+			currentTemp, currentTemp.C(), heatSetPoint, heatSetPoint.C(),
+			coolSetPoint, coolSetPoint.C(), demandMgmtOffset, demandMgmtOffset.C())
+		fmt.Printf("\tcurrent humidity: %d%%\n\thumidity set point: %d\n\tdehumidity set point: %d\n\tHVAC mode: %s\n",
+			currentHumidity, humiditySetPoint, dehumiditySetPoint, hvacMode)
+		fmt.Printf("\tfan runtime: %d seconds\n\thumidifier runtime: %d seconds\n\tdehumidifier runtime: %d seconds\n",
+			fanRunSec, humidifierRunSec, dehumidifierRunSec)
+		fmt.Printf("\theat pump 1 runtime: %d seconds\n\theat pump 2 runtime: %d seconds\n",
+			heatPump1RunSec, heatPump2RunSec)
+		fmt.Printf("\theat 1 runtime: %d seconds\n\theat 2 runtime: %d seconds\n\theat 3 runtime: %d seconds\n",
+			auxHeat1RunSec, auxHeat2RunSec, auxHeat3RunSec)
+		fmt.Printf("\tcool 1 runtime: %d seconds\n\tcool 2 runtime: %d seconds\n",
+			cool1RunSec, cool2RunSec)
+		fmt.Printf("\teconomizer runtime: %d seconds\n\tventilator runtime: %d seconds\n",
+			economizerRunSec, ventilatorRunSec)
+
+		if latestRuntimeInterval != c.lastWrittenRuntimeInterval {
+			fields := map[string]interface{}{
+				"temperature":          currentTemp.Unwrap(),
+				"temperature_f":        currentTemp.Unwrap(),
+				"temperature_c":        currentTemp.C().Unwrap(),
+				"humidity":             currentHumidity,
+				"heat_set_point":       heatSetPoint.Unwrap(),
+				"heat_set_point_f":     heatSetPoint.Unwrap(),
+				"heat_set_point_c":     heatSetPoint.C().Unwrap(),
+				"cool_set_point":       coolSetPoint.Unwrap(),
+				"cool_set_point_f":     coolSetPoint.Unwrap(),
+				"cool_set_point_c":     coolSetPoint.C().Unwrap(),
+				"demand_mgmt_offset":   demandMgmtOffset.Unwrap(),
+				"demand_mgmt_offset_f": demandMgmtOffset.Unwrap(),
+				"demand_mgmt_offset_c": demandMgmtOffset.C().Unwrap(),
+				"fan_run_time":         fanRunSec,
+				"hvac_mode":            hvacMode,
+			}
+			if c.config.WriteHumidifier || c.config.WriteDehumidifier {
+				fields["humidity_set_point"] = humiditySetPoint
+			}
+			if c.config.WriteHumidifier {
+				fields["humidifier_run_time"] = humidifierRunSec
+			}
+			if c.config.WriteDehumidifier {
+				fields["dehumidifier_run_time"] = dehumidifierRunSec
+				fields["dehumidity_set_point"] = dehumiditySetPoint
+			}
+			if c.config.WriteAuxHeat1 {
+				fields["aux_heat_1_run_time"] = auxHeat1RunSec
+			}
+			if c.config.WriteAuxHeat2 {
+				fields["aux_heat_2_run_time"] = auxHeat2RunSec
+			}
+			if c.config.WriteAuxHeat3 {
+				fields["aux_heat_3_run_time"] = auxHeat3RunSec
+			}
+			if c.config.WriteHeatPump1 {
+				fields["heat_pump_1_run_time"] = heatPump1RunSec
+			}
+			if c.config.WriteHeatPump2 {
+				fields["heat_pump_2_run_time"] = heatPump2RunSec
+			}
+			if c.config.WriteCool1 {
+				fields["cool_1_run_time"] = cool1RunSec
+			}
+			if c.config.WriteCool2 {
+				fields["cool_2_run_time"] = cool2RunSec
+			}
+			if c.config.WriteEconomizer {
+				fields["economizer_run_time"] = economizerRunSec
+			}
+			if c.config.WriteVentilator {
+				fields["ventilator_run_time"] = ventilatorRunSec
+			}
+			if err := c.out.write(
+				"ecobee_runtime",
+				map[string]string{thermostatNameTag: t.Name},
+				fields,
+				reportTime,
+				"runtime",
+			); err != nil {
+				return err
+			}
+		}
+	}
+	c.lastWrittenRuntimeInterval = latestRuntimeInterval
+
+	// assume t.LastModified for these:
+	sensorTime, err := time.Parse("2006-01-02 15:04:05", t.UtcTime)
+	if err != nil {
+		return err
+	}
+	participating := participatingSensors(t)
+	for _, sensor := range t.RemoteSensors {
+		name := c.sensorName(sensor)
+		fields := sensorFields(sensor)
+		if len(fields) > 0 {
+			if participating != nil {
+				fields["participating"] = participating[sensor.ID]
+			}
+			fields["ecobee_name"] = sensor.Name
+			fields["in_use"] = sensor.InUse
+		}
+		fmt.Printf("Sensor '%s' at %s:\n", name, sensorTime)
+		for _, k := range sortedKeys(fields) {
+			fmt.Printf("\t%s: %v\n", k, fields[k])
+		}
+
+		if len(fields) == 0 {
+			// no readings from this sensor, so skip writing it
+			continue
+		}
+
+		if sensorTime != c.lastWrittenSensors {
+			tags := map[string]string{
+				thermostatNameTag: t.Name,
+				"sensor_type":     sensor.Type,
+			}
+			if sensor.Code != "" {
+				tags["sensor_code"] = sensor.Code
+			}
+			c.sensorTags(sensor, tags)
+			if err := c.out.write(
+				"ecobee_sensor",
+				tags,
+				fields,
+				sensorTime,
+				c.sensorTopic(sensor),
+			); err != nil {
+				return err
+			}
+		}
+	}
+	if sensorTime != c.lastWrittenSensors {
+		if err := c.processZones(t, sensorTime); err != nil {
+			return err
+		}
+		if err := c.processOccupancy(t, sensorTime); err != nil {
+			return err
+		}
+	}
+	c.lastWrittenSensors = sensorTime
+
+	if err := c.processSettings(t, sensorTime); err != nil {
+		return err
+	}
+
+	if err := c.processConnectivity(t, sensorTime); err != nil {
+		return err
+	}
+
+	if err := c.processAlerts(t, sensorTime); err != nil {
+		return err
+	}
+
+	if err := c.processEvents(t, sensorTime); err != nil {
+		return err
+	}
+
+	if err := c.processProgram(t, sensorTime); err != nil {
+		return err
+	}
+
+	if err := c.processElectricity(t, sensorTime); err != nil {
+		return err
+	}
+
+	if len(t.Weather.Forecasts) == 0 {
+		log.Printf("no weather data available")
+		return nil
+	}
+	weatherTime, err := time.Parse("2006-01-02 15:04:05", t.Weather.Timestamp)
+	if err != nil {
+		return err
+	}
+	outdoorTemp := wx.TempF(float64(t.Weather.Forecasts[0].Temperature) / 10.0)
+	pressureMillibar := wx.PressureMb(t.Weather.Forecasts[0].Pressure)
+	outdoorHumidity := wx.ClampedRelHumidity(t.Weather.Forecasts[0].RelativeHumidity)
+	dewpoint := wx.TempF(float64(t.Weather.Forecasts[0].Dewpoint) / 10.0)
+	windSpeedMph := wx.SpeedMph(t.Weather.Forecasts[0].WindSpeed)
+	windBearing := t.Weather.Forecasts[0].WindBearing
+	visibilityMeters := wx.Meter(t.Weather.Forecasts[0].Visibility)
+	visibilityMiles := visibilityMeters.Miles()
+	windChill := wx.WindChillF(outdoorTemp, windSpeedMph)
+	weatherSymbol := t.Weather.Forecasts[0].WeatherSymbol
+	sky := t.Weather.Forecasts[0].Sky
+	windGustMph := wx.SpeedMph(t.Weather.Forecasts[0].WindGust)
+	windDirection := t.Weather.Forecasts[0].WindDirection
+	condition := t.Weather.Forecasts[0].Condition
+	pop := t.Weather.Forecasts[0].Pop
+
+	fmt.Printf("Weather at %s:\n", weatherTime)
+	fmt.Printf("\ttemperature: %.1f degF (%.1f degC)\n\tpressure: %.0f mb\n\thumidity: %d%%\n\tdew point: %.1f degF (%.1f degC)",
+		outdoorTemp, outdoorTemp.C(), pressureMillibar, outdoorHumidity, dewpoint, dewpoint.C())
+	fmt.Printf("\n\twind: %d at %.0f mph\n\twind chill: %.1f degF\n\tvisibility: %.1f miles\nweather symbol: %d\nsky: %d",
+		windBearing, windSpeedMph, windChill, visibilityMiles, weatherSymbol, sky)
+	fmt.Printf("\n\twind gust: %.0f mph\n\tcondition: %s\n\tchance of precipitation: %d%%\n",
+		windGustMph, condition, pop)
+
+	weatherTags := map[string]string{
+		thermostatNameTag: t.Name,
+		sourceTag:         source,
+	}
+	if t.Weather.WeatherStation != "" {
+		weatherTags["weather_station"] = t.Weather.WeatherStation
+	}
+	newWeather := weatherTime != c.lastWrittenWeather
+
+	if newWeather || c.config.AlwaysWriteWeather {
+		pointTime := weatherTime
+		if c.config.AlwaysWriteWeather {
+			pointTime = time.Now()
+		}
+		if err := c.out.write(
+			ecobeeWeatherMeasurementName,
+			weatherTags,
+			map[string]interface{}{
+				"outdoor_temp":                    outdoorTemp.Unwrap(),
+				"outdoor_temp_f":                  outdoorTemp.Unwrap(),
+				"outdoor_temp_c":                  outdoorTemp.C().Unwrap(),
+				"outdoor_humidity":                outdoorHumidity.Unwrap(),
+				"barometric_pressure_mb":          int(math.Round(pressureMillibar.Unwrap())), // we get int precision from Ecobee, and historically this is written as int
+				"barometric_pressure_inHg":        pressureMillibar.InHg().Unwrap(),
+				"dew_point":                       dewpoint.Unwrap(),
+				"dew_point_f":                     dewpoint.Unwrap(),
+				"dew_point_c":                     dewpoint.C().Unwrap(),
+				"wind_speed":                      int(math.Round(windSpeedMph.Unwrap())), // we get int precision from Ecobee, and historically this is written as int
+				"wind_speed_mph":                  windSpeedMph.Unwrap(),
+				"wind_bearing":                    windBearing,
+				"visibility_mi":                   visibilityMiles.Unwrap(),
+				"visibility_km":                   visibilityMiles.Km().Unwrap(),
+				"recommended_max_indoor_humidity": wx.IndoorHumidityRecommendationF(outdoorTemp).Unwrap(),
+				"wind_chill_f":                    windChill.Unwrap(),
+				"wind_chill_c":                    windChill.C().Unwrap(),
+				"weather_symbol":                  weatherSymbol,
+				"sky":                             sky,
+				"wind_gust_mph":                   windGustMph.Unwrap(),
+				"wind_direction":                  windDirection,
+				"condition":                       condition,
+				"pop":                             pop,
+			},
+			pointTime,
+			"weather",
+		); err != nil {
+			return err
+		}
+	}
+
+	if newWeather {
+		if err := c.processForecasts(t, weatherTime, weatherTags); err != nil {
+			return err
+		}
+		// Only now is this weather report fully written; if anything failed,
+		// a retry writes it again.
+		c.lastWrittenWeather = weatherTime
+	}
+
+	return nil
+}
+
+// processSettings writes the thermostat's settings when they have changed, or
+// when they were last written more than settingsRewriteInterval ago.
+func (c *connector) processSettings(t *ecobee.Thermostat, at time.Time) error {
+	st := t.Settings
+	if st.HvacMode == "" {
+		// settings weren't included in the response (e.g. an archived
+		// response from before they were requested)
+		return nil
+	}
+	if st == c.lastWrittenSettings && at.Sub(c.lastWrittenSettingsAt) < settingsRewriteInterval {
+		return nil
+	}
+
+	tempF := func(v int) wx.TempF { return wx.TempF(float64(v) / 10.0) }
+	heatMin, heatMax := tempF(st.HeatRangeLow), tempF(st.HeatRangeHigh)
+	coolMin, coolMax := tempF(st.CoolRangeLow), tempF(st.CoolRangeHigh)
+	compressorMinOutdoor := tempF(st.CompressorProtectionMinTemp)
+	auxMaxOutdoor := tempF(st.AuxMaxOutdoorTemp)
+
+	fmt.Printf("Settings at %s:\n", at)
+	fmt.Printf("\tHVAC mode: %s\n\theat range: %.1f-%.1f degF\n\tcool range: %.1f-%.1f degF\n",
+		st.HvacMode, heatMin, heatMax, coolMin, coolMax)
+
+	fields := map[string]any{
+		"hvac_mode":                     st.HvacMode,
+		"heat_stages":                   st.HeatStages,
+		"cool_stages":                   st.CoolStages,
+		"heat_set_point_min_f":          heatMin.Unwrap(),
+		"heat_set_point_min_c":          heatMin.C().Unwrap(),
+		"heat_set_point_max_f":          heatMax.Unwrap(),
+		"heat_set_point_max_c":          heatMax.C().Unwrap(),
+		"cool_set_point_min_f":          coolMin.Unwrap(),
+		"cool_set_point_min_c":          coolMin.C().Unwrap(),
+		"cool_set_point_max_f":          coolMax.Unwrap(),
+		"cool_set_point_max_c":          coolMax.C().Unwrap(),
+		"heat_differential_f":           tempDeltaF(st.Stage1HeatingDifferentialTemp),
+		"heat_differential_c":           tempDeltaC(st.Stage1HeatingDifferentialTemp),
+		"cool_differential_f":           tempDeltaF(st.Stage1CoolingDifferentialTemp),
+		"cool_differential_c":           tempDeltaC(st.Stage1CoolingDifferentialTemp),
+		"heat_dissipation_time":         st.Stage1HeatingDissipationTime,
+		"cool_dissipation_time":         st.Stage1CoolingDissipationTime,
+		"heat_cool_min_delta_f":         tempDeltaF(st.HeatCoolMinDelta),
+		"heat_cool_min_delta_c":         tempDeltaC(st.HeatCoolMinDelta),
+		"temp_correction_f":             tempDeltaF(st.TempCorrection),
+		"temp_correction_c":             tempDeltaC(st.TempCorrection),
+		"compressor_min_cycle_off_time": st.CompressorProtectionMinTime,
+		"compressor_min_outdoor_temp_f": compressorMinOutdoor.Unwrap(),
+		"compressor_min_outdoor_temp_c": compressorMinOutdoor.C().Unwrap(),
+		"aux_max_outdoor_temp_f":        auxMaxOutdoor.Unwrap(),
+		"aux_max_outdoor_temp_c":        auxMaxOutdoor.C().Unwrap(),
+		"fan_min_on_time":               st.FanMinOnTime,
+		"humidifier_mode":               st.HumidifierMode,
+		"dehumidifier_mode":             st.DehumidifierMode,
+		"dehumidifier_level":            st.DehumidifierLevel,
+		"dehumidify_with_ac":            st.DehumidifyWithAC,
+		"dehumidify_overcool_offset_f":  tempDeltaF(st.DehumidifyOvercoolOffset),
+		"dehumidify_overcool_offset_c":  tempDeltaC(st.DehumidifyOvercoolOffset),
+		"auto_heat_cool_enabled":        st.AutoHeatCoolFeatureEnabled,
+		"disable_pre_heating":           st.DisablePreHeating,
+		"disable_pre_cooling":           st.DisablePreCooling,
+		"hold_action":                   st.HoldAction,
+		"smart_away":                    st.AutoAway,
+		"follow_me":                     st.FollowMeComfort,
+		"smart_circulation":             st.SmartCirculation,
+		"ventilator_min_on_time":        st.VentilatorMinOnTime,
+		"ventilator_min_on_time_home":   st.VentilatorMinOnTimeHome,
+		"ventilator_min_on_time_away":   st.VentilatorMinOnTimeAway,
+		"cooling_lockout":               st.CoolingLockout,
+		"heat_pump_reversal_on_cool":    st.HeatPumpReversalOnCool,
+		"fan_control_required":          st.FanControlRequired,
+		"condensation_avoid":            st.CondensationAvoid,
+		"months_between_service":        st.MonthsBetweenService,
+	}
+	if humidity, err := strconv.Atoi(st.Humidity); err == nil {
+		fields["humidity_set_point"] = humidity
+	}
+	if err := c.out.write(
+		"ecobee_settings",
+		map[string]string{thermostatNameTag: t.Name},
+		fields,
+		at,
+		"settings",
+	); err != nil {
+		return err
+	}
+	c.lastWrittenSettings = st
+	c.lastWrittenSettingsAt = at
+	return nil
+}
+
+// thermostatUTCOffset returns the offset of the thermostat's local time zone
+// from UTC, as implied by the local and UTC times in its response.
+func thermostatUTCOffset(t *ecobee.Thermostat) time.Duration {
+	local, err := time.Parse("2006-01-02 15:04:05", t.ThermostatTime)
+	if err != nil {
+		return 0
+	}
+	utc, err := time.Parse("2006-01-02 15:04:05", t.UtcTime)
+	if err != nil {
+		return 0
+	}
+	return local.Sub(utc).Round(15 * time.Minute)
+}
+
+// tempDeltaF converts a temperature difference in tenths of a degree F, as
+// used by Ecobee, to degrees F.
+func tempDeltaF(tenths int) float64 {
+	return float64(tenths) / 10.0
+}
+
+// tempDeltaC converts a temperature difference in tenths of a degree F to
+// degrees C.
+func tempDeltaC(tenths int) float64 {
+	return float64(tenths) / 10.0 / 1.8
+}
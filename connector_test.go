@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+
+	"ecobee_influx_connector/ecobee"
+	"ecobee_influx_connector/ecobee/ecobeetest"
+)
+
+// fakeWriteAPI records the points written to it, in place of InfluxDB.
+type fakeWriteAPI struct {
+	mu     sync.Mutex
+	points []*write.Point
+}
+
+func (f *fakeWriteAPI) WriteRecord(context.Context, ...string) error { return nil }
+
+func (f *fakeWriteAPI) WritePoint(_ context.Context, points ...*write.Point) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.points = append(f.points, points...)
+	return nil
+}
+
+func (f *fakeWriteAPI) EnableBatching() {}
+
+func (f *fakeWriteAPI) Flush(context.Context) error { return nil }
+
+// byMeasurement returns the points written to the given measurement.
+func (f *fakeWriteAPI) byMeasurement(measurement string) []*write.Point {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var points []*write.Point
+	for _, p := range f.points {
+		if p.Name() == measurement {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func pointTag(p *write.Point, key string) (string, bool) {
+	for _, t := range p.TagList() {
+		if t.Key == key {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+func pointField(p *write.Point, key string) (any, bool) {
+	for _, f := range p.FieldList() {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// fetchSimulatedThermostat authorizes against a fake Ecobee API, as
+// -simulate does, and fetches its thermostat.
+func fetchSimulatedThermostat(t *testing.T) *ecobee.Thermostat {
+	t.Helper()
+	sim := ecobeetest.NewServer()
+	t.Cleanup(sim.Close)
+
+	opts := []ecobee.ClientOption{ecobee.WithBaseURL(sim.URL)}
+	cachePath := filepath.Join(t.TempDir(), credCacheFileName)
+	pin, err := ecobee.Authorize("test", opts...)
+	if err != nil {
+		t.Fatalf("Authorize: %s", err)
+	}
+	if err := ecobee.SaveToken("test", cachePath, pin.Code, opts...); err != nil {
+		t.Fatalf("SaveToken: %s", err)
+	}
+	client := ecobee.NewClient("test", cachePath, append(opts, ecobee.NonInteractive())...)
+	thermostat, _, err := client.GetThermostatRawContext(context.Background(), ecobeetest.ThermostatID)
+	if err != nil {
+		t.Fatalf("GetThermostatRawContext: %s", err)
+	}
+	return thermostat
+}
+
+// newTestConnector returns a connector writing to influx, which keeps its
+// state in memory.
+func newTestConnector(t *testing.T, config Config, influx *fakeWriteAPI) *connector {
+	t.Helper()
+	conn, err := newConnector(config, &output{config: config, influxWriteAPI: influx}, "")
+	if err != nil {
+		t.Fatalf("newConnector: %s", err)
+	}
+	return conn
+}
+
+func TestProcessSimulatedThermostat(t *testing.T) {
+	thermostat := fetchSimulatedThermostat(t)
+
+	config := Config{ThermostatID: ecobeetest.ThermostatID}
+	influx := &fakeWriteAPI{}
+	conn := newTestConnector(t, config, influx)
+	if err := conn.process(thermostat); err != nil {
+		t.Fatalf("process: %s", err)
+	}
+
+	for _, measurement := range []string{
+		"ecobee_air_quality",
+		"ecobee_runtime",
+		"ecobee_sensor",
+		"ecobee_weather",
+		"ecobee_settings",
+		"ecobee_alert",
+		"ecobee_event",
+		"ecobee_current_climate",
+		"ecobee_climate",
+	} {
+		if len(influx.byMeasurement(measurement)) == 0 {
+			t.Errorf("no points written to %s", measurement)
+		}
+	}
+
+	sensorIDs := make(map[string]bool)
+	for _, p := range influx.byMeasurement("ecobee_sensor") {
+		if name, _ := pointTag(p, thermostatNameTag); name != thermostat.Name {
+			t.Errorf("sensor point has %s tag '%s'; want '%s'", thermostatNameTag, name, thermostat.Name)
+		}
+		id, ok := pointTag(p, "sensor_id")
+		if !ok {
+			t.Errorf("sensor point has no sensor_id tag")
+			continue
+		}
+		sensorIDs[id] = true
+		if _, ok := pointField(p, "ecobee_name"); !ok {
+			t.Errorf("sensor %s point has no ecobee_name field", id)
+		}
+	}
+	for _, sensor := range thermostat.RemoteSensors {
+		if !sensorIDs[sensor.ID] {
+			t.Errorf("no point written for sensor %s", sensor.ID)
+		}
+	}
+
+	if got, want := len(influx.byMeasurement("ecobee_climate")), len(thermostat.Program.Climates); got != want {
+		t.Errorf("wrote %d ecobee_climate points; want %d", got, want)
+	}
+}
+
+func TestProcessSkipsUnchangedData(t *testing.T) {
+	thermostat := fetchSimulatedThermostat(t)
+
+	config := Config{ThermostatID: ecobeetest.ThermostatID}
+	influx := &fakeWriteAPI{}
+	conn := newTestConnector(t, config, influx)
+	if err := conn.process(thermostat); err != nil {
+		t.Fatalf("process: %s", err)
+	}
+	counts := make(map[string]int)
+	for _, measurement := range []string{"ecobee_runtime", "ecobee_sensor", "ecobee_weather", "ecobee_settings", "ecobee_alert", "ecobee_climate"} {
+		counts[measurement] = len(influx.byMeasurement(measurement))
+	}
+
+	// Processing the same response again writes nothing new for data which
+	// is only written when it changes:
+	if err := conn.process(thermostat); err != nil {
+		t.Fatalf("process: %s", err)
+	}
+	for measurement, want := range counts {
+		if got := len(influx.byMeasurement(measurement)); got != want {
+			t.Errorf("%s: %d points after reprocessing; want %d", measurement, got, want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const (
+	deviceStateFileName = "ecobee-device-state.json"
+
+	// deviceInfoInterval is how often the thermostat's device, version and
+	// location are fetched. They rarely change, so there's no need to fetch
+	// them with every poll.
+	deviceInfoInterval = 6 * time.Hour
+)
+
+// deviceState records the thermostat's last seen firmware version, so that
+// upgrades are noticed across restarts.
+type deviceState struct {
+	FirmwareVersion string `json:"firmware_version"`
+}
+
+// deviceInfoWatcher periodically writes the thermostat's hardware, firmware
+// and location to the ecobee_device_info measurement, publishes them to Home
+// Assistant if configured, and records firmware upgrades as configuration
+// changes.
+type deviceInfoWatcher struct {
+	client   *ecobee.Client
+	config   Config
+	out      *output
+	stateDir string // empty to keep state in memory
+	state    deviceState
+}
+
+func newDeviceInfoWatcher(client *ecobee.Client, config Config, out *output, stateDir string) (*deviceInfoWatcher, error) {
+	w := &deviceInfoWatcher{client: client, config: config, out: out, stateDir: stateDir}
+	if stateDir != "" {
+		if err := loadState(stateDir, deviceStateFileName, &w.state); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// run writes the device info every deviceInfoInterval until ctx is done.
+func (w *deviceInfoWatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(deviceInfoInterval)
+	defer ticker.Stop()
+	for {
+		if err := w.check(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to update device info: %s", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *deviceInfoWatcher) check(ctx context.Context) error {
+	ts, err := w.client.GetThermostatsContext(ctx, ecobee.Selection{
+		SelectionType:   "thermostats",
+		SelectionMatch:  w.config.ThermostatID,
+		IncludeDevice:   true,
+		IncludeVersion:  true,
+		IncludeLocation: true,
+	})
+	if err != nil {
+		return err
+	}
+	if len(ts) != 1 {
+		return fmt.Errorf("expected 1 thermostat, got %d", len(ts))
+	}
+	t := ts[0]
+	now := time.Now()
+
+	if err := w.out.write(
+		"ecobee_device_info",
+		map[string]string{
+			thermostatNameTag: t.Name,
+			"model_number":    t.ModelNumber,
+			"brand":           t.Brand,
+		},
+		deviceInfoFields(&t),
+		now,
+		"device_info",
+	); err != nil {
+		return err
+	}
+	if w.out.mqttClient != nil && w.config.MQTT.HomeAssistantDiscovery {
+		if err := publishHomeAssistantDiscovery(w.out.mqttClient, w.config, &t); err != nil {
+			return err
+		}
+	}
+
+	firmware := t.Version.ThermostatFirmwareVersion
+	if firmware == "" || firmware == w.state.FirmwareVersion {
+		return nil
+	}
+	if w.state.FirmwareVersion != "" {
+		log.Printf("Thermostat '%s' firmware upgraded from %s to %s", t.Name, w.state.FirmwareVersion, firmware)
+		c := configChange{Field: "thermostatFirmwareVersion", Old: w.state.FirmwareVersion, New: firmware}
+		if err := recordConfigChange(w.out, t.Name, "version", c, t.ThermostatRev, now); err != nil {
+			return err
+		}
+	}
+	w.state.FirmwareVersion = firmware
+	if w.stateDir != "" {
+		if err := saveState(w.stateDir, deviceStateFileName, w.state); err != nil {
+			log.Printf("failed to save device state: %s", err)
+		}
+	}
+	return nil
+}
+
+// deviceInfoFields returns the fields of an ecobee_device_info point. The
+// model number and brand are written as tags instead, and the street address
+// and phone number are left out.
+func deviceInfoFields(t *ecobee.Thermostat) map[string]any {
+	outputs := 0
+	for _, d := range t.Devices {
+		outputs += len(d.Outputs)
+	}
+	fields := map[string]any{
+		"thermostat_id":            t.Identifier,
+		"firmware_version":         t.Version.ThermostatFirmwareVersion,
+		"features":                 t.Features,
+		"is_registered":            t.IsRegistered,
+		"time_zone":                t.Location.TimeZone,
+		"time_zone_offset_minutes": t.Location.TimeZoneOffsetMinutes,
+		"is_daylight_saving":       t.Location.IsDaylightSaving,
+		"city":                     t.Location.City,
+		"province_state":           t.Location.ProvinceState,
+		"country":                  t.Location.Country,
+		"device_count":             len(t.Devices),
+		"output_count":             outputs,
+	}
+	if lat, lon, ok := parseMapCoordinates(t.Location.MapCoordinates); ok {
+		fields["latitude"] = lat
+		fields["longitude"] = lon
+	}
+	return fields
+}
+
+// parseMapCoordinates parses a location's "latitude, longitude" coordinates.
+func parseMapCoordinates(s string) (float64, float64, bool) {
+	latStr, lonStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
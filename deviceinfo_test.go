@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+func TestParseMapCoordinates(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		lat, lon float64
+		ok       bool
+	}{
+		{"42.28,-83.74", 42.28, -83.74, true},
+		{" 42.28 , -83.74 ", 42.28, -83.74, true},
+		{"-33.86,151.21", -33.86, 151.21, true},
+		{"", 0, 0, false},
+		{"42.28", 0, 0, false},
+		{"north,-83.74", 0, 0, false},
+		{"42.28,west", 0, 0, false},
+	} {
+		lat, lon, ok := parseMapCoordinates(tc.in)
+		if lat != tc.lat || lon != tc.lon || ok != tc.ok {
+			t.Errorf("parseMapCoordinates(%q) = %v, %v, %t; want %v, %v, %t",
+				tc.in, lat, lon, ok, tc.lat, tc.lon, tc.ok)
+		}
+	}
+}
+
+func TestHomeAssistantDiscovery(t *testing.T) {
+	cfg := Config{ThermostatID: "311000000001", MQTT: MQTTConfig{TopicRoot: "ecobee"}}
+	thermostat := &ecobee.Thermostat{Name: "Home", ModelNumber: "athenaSmart", Brand: "ecobee"}
+	thermostat.Version.ThermostatFirmwareVersion = "4.8.7.123"
+
+	configs := homeAssistantDiscovery(cfg, thermostat)
+	if len(configs) != len(haEntities) {
+		t.Errorf("got %d discovery configs; want %d", len(configs), len(haEntities))
+	}
+	c, ok := configs["homeassistant/sensor/ecobee_311000000001/runtime_temperature_f/config"]
+	if !ok {
+		t.Fatalf("no discovery config for the runtime temperature; got %v", configs)
+	}
+	if c.StateTopic != "ecobee/311000000001/runtime/temperature_f" {
+		t.Errorf("state topic = %s; want ecobee/311000000001/runtime/temperature_f", c.StateTopic)
+	}
+	want := haDevice{
+		Identifiers:  []string{"ecobee_311000000001"},
+		Name:         "Home",
+		Manufacturer: "ecobee",
+		Model:        "athenaSmart",
+		SWVersion:    "4.8.7.123",
+	}
+	if !reflect.DeepEqual(c.Device, want) {
+		t.Errorf("device = %+v; want %+v", c.Device, want)
+	}
+
+	cfg.MQTT.HomeAssistantPrefix = "ha"
+	if _, ok := homeAssistantDiscovery(cfg, thermostat)["ha/sensor/ecobee_311000000001/device_info_firmware_version/config"]; !ok {
+		t.Errorf("configured discovery prefix not used")
+	}
+}
@@ -17,11 +17,13 @@ package ecobee
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -32,43 +34,60 @@ import (
 // Scopes defines the scopes we request from the API.
 var Scopes = []string{"smartRead", "smartWrite"}
 
+// ErrAuthorizationRequired is returned by a non-interactive client when no
+// cached credentials are available.
+var ErrAuthorizationRequired = errors.New("authorization required: no cached credentials")
+
 type tokenSource struct {
-	token               oauth2.Token
-	cacheFile, clientID string
+	mu             sync.Mutex
+	token          oauth2.Token
+	cache          credCache
+	clientID       string
+	nonInteractive bool
+	loadErr        error // set if the credential cache could not be read
+	revoked        bool  // set when Ecobee rejects the refresh token
+	httpClient     *http.Client
+	baseURL        string
 }
 
 func TokenSource(clientID, cacheFile string) oauth2.TokenSource {
-	return oauth2.ReuseTokenSource(nil, newTokenSource(clientID, cacheFile))
+	return oauth2.ReuseTokenSource(nil, newTokenSource(clientID, cacheFile, buildClientOptions(nil)))
 }
 
-func newTokenSource(clientID, cacheFile string) *tokenSource {
-	file, err := ioutil.ReadFile(cacheFile)
+// HasCachedToken reports whether the given cache file holds a refresh token
+// which can be used without (re-)authorizing the application. It returns an
+// error wrapping ErrCredCacheCorrupt if the cache exists but can't be read.
+func HasCachedToken(cacheFile string, opts ...ClientOption) (bool, error) {
+	tok, err := newCredCache(cacheFile, buildClientOptions(opts).cacheKey).load()
 	if err != nil {
-		// no file, corrupted, or other problem: just start with an
-		// empty token.
-		return &tokenSource{clientID: clientID, cacheFile: cacheFile}
+		return false, err
 	}
-	var tok oauth2.Token
-	err = json.Unmarshal(file, &tok)
-	if err != nil {
-		// can't unmarshal?  Return an empty token.
-		return &tokenSource{clientID: clientID, cacheFile: cacheFile}
+	return len(tok.RefreshToken) > 0, nil
+}
+
+func newTokenSource(clientID, cacheFile string, o clientOptions) *tokenSource {
+	cache := newCredCache(cacheFile, o.cacheKey)
+	tok, err := cache.load()
+	return &tokenSource{
+		clientID:       clientID,
+		cache:          cache,
+		token:          tok,
+		loadErr:        err,
+		nonInteractive: o.nonInteractive,
+		httpClient:     o.httpClient(),
+		baseURL:        o.baseURL,
 	}
-	return &tokenSource{clientID: clientID, cacheFile: cacheFile, token: tok}
 }
 
 func (ts *tokenSource) save() error {
-	d, err := json.Marshal(ts.token)
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(ts.cacheFile, d, 0777)
-	return err
+	return ts.cache.save(ts.token)
 }
 
 type PinResponse struct {
 	EcobeePin string `json:"ecobeePin"`
 	Code      string `json:"code"`
+	ExpiresIn int    `json:"expires_in"` // minutes
+	Interval  int    `json:"interval"`   // seconds
 }
 
 // Interactive authentication, triggered on initial use of the client
@@ -91,14 +110,7 @@ func (ts *tokenSource) authorize() (*PinResponse, error) {
 		"client_id":     {ts.clientID},
 		"scope":         {strings.Join(Scopes, ",")},
 	}
-	u := url.URL{
-		Scheme:   "https",
-		Host:     "api.ecobee.com",
-		Path:     "authorize",
-		RawQuery: uv.Encode(),
-	}
-
-	resp, err := http.Get(u.String())
+	resp, err := ts.httpClient.Get(ts.baseURL + "/authorize?" + uv.Encode())
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving response: %s", err)
 	}
@@ -154,19 +166,13 @@ func (ts *tokenSource) refreshToken() error {
 }
 
 func (ts *tokenSource) getToken(uv url.Values) error {
-	u := url.URL{
-		Scheme:   "https",
-		Host:     "api.ecobee.com",
-		Path:     "token",
-		RawQuery: uv.Encode(),
-	}
-	resp, err := http.PostForm(u.String(), nil)
+	resp, err := ts.httpClient.PostForm(ts.baseURL+"/token?"+uv.Encode(), nil)
 	if err != nil {
 		return fmt.Errorf("error POSTing request: %s", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("invalid server response: %v", resp.Status)
+		return newAuthError(resp)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -192,12 +198,19 @@ func (ts *tokenSource) getToken(uv url.Values) error {
 }
 
 func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.loadErr != nil {
+		return nil, ts.loadErr
+	}
 	if !ts.token.Valid() {
 		if len(ts.token.RefreshToken) > 0 {
-			err := ts.refreshToken()
+			err := ts.refreshLocked()
 			if err != nil {
-				return nil, fmt.Errorf("error refreshing token: %s", err)
+				return nil, fmt.Errorf("error refreshing token: %w", err)
 			}
+		} else if ts.nonInteractive {
+			return nil, ErrAuthorizationRequired
 		} else {
 			err := ts.firstAuth()
 			if err != nil {
@@ -205,33 +218,169 @@ func (ts *tokenSource) Token() (*oauth2.Token, error) {
 			}
 		}
 	}
-	return &ts.token, nil
+	tok := ts.token
+	return &tok, nil
+}
+
+// refreshLocked refreshes the access token. If a previous refresh found the
+// refresh token revoked, the cache file is re-read first, so credentials
+// saved by a separate re-authorization are picked up. ts.mu must be held.
+func (ts *tokenSource) refreshLocked() error {
+	if ts.revoked {
+		if tok, err := ts.cache.load(); err == nil && tok.RefreshToken != ts.token.RefreshToken {
+			ts.token = tok
+			ts.revoked = false
+		}
+	}
+	err := ts.refreshToken()
+	var authErr *AuthError
+	if errors.As(err, &authErr) && authErr.Revoked() {
+		ts.revoked = true
+	}
+	return err
+}
+
+func (ts *tokenSource) expiry() time.Time {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.token.Expiry
+}
+
+// expire marks the access token as expired, so that the next call to Token
+// refreshes it.
+func (ts *tokenSource) expire() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.token.AccessToken = ""
+}
+
+func (ts *tokenSource) forceRefresh() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.loadErr != nil {
+		return ts.loadErr
+	}
+	if len(ts.token.RefreshToken) == 0 {
+		return ErrAuthorizationRequired
+	}
+	return ts.refreshLocked()
+}
+
+// AuthError describes an error response from Ecobee's OAuth token endpoint.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/auth/auth-req-resp.shtml
+type AuthError struct {
+	StatusCode  int
+	Code        string // e.g. "invalid_grant", "authorization_expired"
+	Description string
+}
+
+func newAuthError(resp *http.Response) *AuthError {
+	e := &AuthError{StatusCode: resp.StatusCode}
+	var r struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if body, err := ioutil.ReadAll(resp.Body); err == nil && json.Unmarshal(body, &r) == nil {
+		e.Code = r.Error
+		e.Description = r.ErrorDescription
+	}
+	return e
+}
+
+func (e *AuthError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("invalid server response: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	if e.Description == "" {
+		return fmt.Sprintf("auth error %s (HTTP %d)", e.Code, e.StatusCode)
+	}
+	return fmt.Sprintf("auth error %s (HTTP %d): %s", e.Code, e.StatusCode, e.Description)
+}
+
+// Revoked reports whether the error means the refresh token (or the pending
+// authorization code) is no longer usable, so the application must be
+// re-authorized.
+func (e *AuthError) Revoked() bool {
+	switch e.Code {
+	case "invalid_grant", "authorization_expired", "invalid_client", "account_locked", "account_disabled":
+		return true
+	}
+	return false
+}
+
+// IsAuthRevoked reports whether err was caused by Ecobee rejecting the
+// application's credentials, meaning the application must be re-authorized.
+func IsAuthRevoked(err error) bool {
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr.Revoked()
+	}
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == StatusInvalidToken
 }
 
 // Client represents the Ecobee API client.
 type Client struct {
 	*http.Client
+	ts      *tokenSource
+	baseURL string
 }
 
 // NewClient creates a Ecobee API client for the specific clientID
 // (Application Key).  Use the Ecobee Developer Portal to create the
 // Application Key.
 // (https://www.ecobee.com/consumerportal/index.html#/dev)
-func NewClient(clientID, cacheFile string) *Client {
-	return &Client{oauth2.NewClient(
-		context.Background(), TokenSource(clientID, cacheFile))}
+func NewClient(clientID, cacheFile string, opts ...ClientOption) *Client {
+	o := buildClientOptions(opts)
+	ts := newTokenSource(clientID, cacheFile, o)
+	// ts caches the token itself; it isn't wrapped in a ReuseTokenSource so
+	// that a token the API reports as expired can be discarded.
+	hc := oauth2.NewClient(context.WithValue(context.Background(), oauth2.HTTPClient, ts.httpClient), ts)
+	hc.Timeout = o.timeout
+	return &Client{
+		Client:  hc,
+		ts:      ts,
+		baseURL: o.baseURL,
+	}
+}
+
+// RefreshTokenAhead keeps the access token fresh in the background,
+// refreshing it lead before it expires rather than when the next request
+// finds it expired. onResult, if non-nil, is called with the outcome of each
+// refresh attempt; failed attempts are retried every retryDelay. It blocks
+// until ctx is done.
+func (c *Client) RefreshTokenAhead(ctx context.Context, lead, retryDelay time.Duration, onResult func(error)) {
+	var lastErr error
+	for {
+		// An already-expired token is refreshed lazily by the next request,
+		// so never wait less than retryDelay between attempts.
+		wait := time.Until(c.ts.expiry().Add(-lead))
+		if lastErr != nil || wait < retryDelay {
+			wait = retryDelay
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		lastErr = c.ts.forceRefresh()
+		if onResult != nil {
+			onResult(lastErr)
+		}
+	}
 }
 
 // Authorize retrieves an ecobee Pin and Code, allowing calling code to present them to the user
 // outside of the ecobee request context.
 // This is useful when non-interactive authorization is required.
 // For example: an app being deployed and authorized using ansible, which does not support interacting with commands.
-func Authorize(clientID string) (*PinResponse, error) {
-	return newTokenSource(clientID, "").authorize()
+func Authorize(clientID string, opts ...ClientOption) (*PinResponse, error) {
+	return newTokenSource(clientID, "", buildClientOptions(opts)).authorize()
 }
 
 // SaveToken retreives a new token from ecobee and saves it to the auth cache
 // after a pin/code combination has been added by an ecobee user.
-func SaveToken(clientID string, cacheFile string, code string) error {
-	return newTokenSource(clientID, cacheFile).accessToken(code)
+func SaveToken(clientID string, cacheFile string, code string, opts ...ClientOption) error {
+	return newTokenSource(clientID, cacheFile, buildClientOptions(opts)).accessToken(code)
 }
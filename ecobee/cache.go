@@ -0,0 +1,234 @@
+package ecobee
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/oauth2"
+)
+
+// ErrCredCacheCorrupt is returned when the credential cache exists but cannot
+// be read back into a token.
+var ErrCredCacheCorrupt = errors.New("credential cache is corrupt")
+
+const (
+	// encryptedCachePrefix marks a credential cache file whose contents are
+	// encrypted with AES-GCM, using a key derived from the passphrase and a
+	// random salt with scrypt. The file is
+	// "<prefix><base64 salt>:<base64 nonce and ciphertext>".
+	encryptedCachePrefix = "ecobee-enc-v1:"
+
+	cacheSaltSize = 16
+)
+
+// scrypt parameters for deriving the cache key, as recommended for
+// interactive logins in 2017; deriving a key takes around 100ms.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// credCache stores the OAuth token on disk. Writes are atomic, and the
+// previous cache file is kept alongside it with a .bak suffix. If the cache
+// is encrypted, plaintext backups are never kept.
+type credCache struct {
+	path       string
+	passphrase string // optional; empty means the cache is stored in plaintext
+}
+
+func newCredCache(path, passphrase string) credCache {
+	return credCache{path: path, passphrase: passphrase}
+}
+
+func (c credCache) encrypted() bool {
+	return c.passphrase != ""
+}
+
+func (c credCache) backupPath() string {
+	return c.path + ".bak"
+}
+
+// load returns the cached token. A missing cache file yields an empty token
+// and no error; an unreadable one yields an error wrapping ErrCredCacheCorrupt.
+func (c credCache) load() (oauth2.Token, error) {
+	var tok oauth2.Token
+	if c.path == "" {
+		return tok, nil
+	}
+	file, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tok, nil
+	} else if err != nil {
+		return tok, fmt.Errorf("error reading credential cache '%s': %w", c.path, err)
+	}
+
+	if isEncryptedCache(file) {
+		if !c.encrypted() {
+			return tok, fmt.Errorf("%w: '%s' is encrypted, but no key was provided", ErrCredCacheCorrupt, c.path)
+		}
+		file, err = c.decrypt(string(file))
+		if err != nil {
+			return tok, fmt.Errorf("%w: '%s' could not be decrypted (wrong key?): %s", ErrCredCacheCorrupt, c.path, err)
+		}
+	}
+
+	if err := json.Unmarshal(file, &tok); err != nil {
+		return tok, fmt.Errorf("%w: '%s' could not be parsed (a backup may exist at '%s'): %s",
+			ErrCredCacheCorrupt, c.path, c.backupPath(), err)
+	}
+	return tok, nil
+}
+
+func isEncryptedCache(file []byte) bool {
+	return strings.HasPrefix(string(file), encryptedCachePrefix)
+}
+
+// save atomically replaces the cache file with the given token, first copying
+// the existing cache (if it's readable) to the backup path. When the cache is
+// encrypted, a plaintext cache isn't backed up, and a plaintext backup left
+// from before encryption was enabled is removed.
+func (c credCache) save(tok oauth2.Token) error {
+	d, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	if c.encrypted() {
+		enc, err := c.encrypt(d)
+		if err != nil {
+			return fmt.Errorf("error encrypting credential cache: %w", err)
+		}
+		d = []byte(enc)
+	}
+
+	// Only a readable cache is worth backing up; never replace a good backup
+	// with a corrupt file.
+	if prev, err := c.load(); err == nil && prev.RefreshToken != "" {
+		prevBytes, err := os.ReadFile(c.path)
+		if err != nil {
+			return fmt.Errorf("error reading credential cache for backup: %w", err)
+		}
+		if !c.encrypted() || isEncryptedCache(prevBytes) {
+			if err := writeFileAtomic(c.backupPath(), prevBytes); err != nil {
+				return fmt.Errorf("error backing up credential cache: %w", err)
+			}
+		}
+	}
+
+	if err := writeFileAtomic(c.path, d); err != nil {
+		return err
+	}
+	if c.encrypted() {
+		backup, err := os.ReadFile(c.backupPath())
+		if err == nil && !isEncryptedCache(backup) {
+			if err := os.Remove(c.backupPath()); err != nil {
+				return fmt.Errorf("error removing plaintext credential cache backup: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// encrypt seals plaintext with a key derived from the passphrase and a new
+// random salt, returning the contents of an encrypted cache file.
+func (c credCache) encrypt(plaintext []byte) (string, error) {
+	salt := make([]byte, cacheSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(c.passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return encryptedCachePrefix + base64.StdEncoding.EncodeToString(salt) + ":" +
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens the contents of an encrypted cache file.
+func (c credCache) decrypt(file string) ([]byte, error) {
+	saltStr, sealedStr, ok := strings.Cut(strings.TrimPrefix(file, encryptedCachePrefix), ":")
+	if !ok {
+		return nil, errors.New("missing salt")
+	}
+	salt, err := base64.StdEncoding.DecodeString(saltStr)
+	if err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key([]byte(c.passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sealedStr))
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path, with 0600 permissions, then renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, base+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer func() {
+		_ = os.Remove(tmpPath) // no-op after a successful rename
+	}()
+
+	if err := f.Chmod(0600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,58 @@
+package ecobee
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestCredCacheEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cred-cache")
+	tok := oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+
+	// A plaintext cache, which is then encrypted by saving with a key:
+	if err := newCredCache(path, "").save(tok); err != nil {
+		t.Fatalf("save plaintext: %s", err)
+	}
+	cache := newCredCache(path, "correct horse")
+	if err := cache.save(tok); err != nil {
+		t.Fatalf("save encrypted: %s", err)
+	}
+	tok.AccessToken = "access 2"
+	if err := cache.save(tok); err != nil {
+		t.Fatalf("save encrypted: %s", err)
+	}
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(file), encryptedCachePrefix) || strings.Contains(string(file), "refresh") {
+		t.Errorf("cache file isn't encrypted: %s", file)
+	}
+	backup, err := os.ReadFile(cache.backupPath())
+	if err != nil {
+		t.Fatalf("reading backup: %s", err)
+	}
+	if !isEncryptedCache(backup) {
+		t.Errorf("backup of an encrypted cache isn't encrypted: %s", backup)
+	}
+
+	got, err := cache.load()
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if got.AccessToken != tok.AccessToken || got.RefreshToken != tok.RefreshToken {
+		t.Errorf("load = %+v; want %+v", got, tok)
+	}
+
+	for _, passphrase := range []string{"", "wrong"} {
+		if _, err := newCredCache(path, passphrase).load(); !errors.Is(err, ErrCredCacheCorrupt) {
+			t.Errorf("load with key %q: err = %v; want ErrCredCacheCorrupt", passphrase, err)
+		}
+	}
+}
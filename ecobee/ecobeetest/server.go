@@ -0,0 +1,251 @@
+// Package ecobeetest provides a fake Ecobee API server for local development,
+// demos, and tests. It serves a single synthetic thermostat whose
+// temperatures, equipment runtime, sensors, occupancy and weather evolve
+// over time.
+package ecobeetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// ThermostatID is the identifier of the fake server's thermostat.
+const ThermostatID = "311000000001"
+
+// Server is a fake Ecobee API server, listening on a local loopback address.
+// Its authorization endpoints approve every request immediately.
+type Server struct {
+	// URL is the server's base URL, for use with ecobee.WithBaseURL.
+	URL string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	sim      *simulation
+	tokenSeq int
+	revoked  map[string]bool // refresh tokens which have been revoked
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		sim:     newSimulation(time.Now),
+		revoked: make(map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.handleAuthorize)
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/1/thermostat", s.requireAuth(s.handleThermostat))
+	mux.HandleFunc("/1/thermostatSummary", s.requireAuth(s.handleThermostatSummary))
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// RevokeTokens revokes every refresh token issued so far, so that the next
+// refresh attempt fails with an invalid_grant error.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 1; i <= s.tokenSeq; i++ {
+		s.revoked[fmt.Sprintf("fake-refresh-%d", i)] = true
+	}
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("response_type") != "ecobeePin" {
+		writeAuthError(w, "unsupported_grant_type", "response_type must be ecobeePin")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ecobeePin":  "FAKE-PIN",
+		"code":       "fake-authorization-code",
+		"scope":      r.URL.Query().Get("scope"),
+		"expires_in": 9,
+		"interval":   30,
+	})
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAuthError(w, "invalid_request", "token requests must be POSTed")
+		return
+	}
+	q := r.URL.Query()
+	switch q.Get("grant_type") {
+	case "ecobeePin":
+		if q.Get("code") == "" {
+			writeAuthError(w, "invalid_grant", "missing code")
+			return
+		}
+	case "refresh_token":
+		s.mu.Lock()
+		revoked := s.revoked[q.Get("refresh_token")]
+		s.mu.Unlock()
+		if q.Get("refresh_token") == "" || revoked {
+			writeAuthError(w, "invalid_grant", "The refresh token has been revoked.")
+			return
+		}
+	default:
+		writeAuthError(w, "unsupported_grant_type", "unknown grant_type")
+		return
+	}
+
+	s.mu.Lock()
+	s.tokenSeq++
+	seq := s.tokenSeq
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  fmt.Sprintf("fake-access-%d", seq),
+		"refresh_token": fmt.Sprintf("fake-refresh-%d", seq),
+		"expires_in":    3600,
+		"token_type":    "Bearer",
+		"scope":         "smartWrite",
+	})
+}
+
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer fake-access-") {
+			writeStatus(w, http.StatusInternalServerError, ecobee.StatusAuthenticationFailed, "Authentication failed.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleThermostat(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var req ecobee.GetThermostatsRequest
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("json")), &req); err != nil {
+			writeStatus(w, http.StatusInternalServerError, ecobee.StatusSerializationError, err.Error())
+			return
+		}
+		if !selects(req.Selection) || (req.Page != nil && req.Page.Page > 1) {
+			writeJSON(w, http.StatusOK, map[string]any{
+				"thermostatList": []ecobee.Thermostat{},
+				"status":         ecobee.Status{},
+			})
+			return
+		}
+		s.mu.Lock()
+		t := s.sim.thermostat(req.Selection)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"page":           ecobee.Page{Page: 1, TotalPages: 1, PageSize: 1, Total: 1},
+			"thermostatList": []ecobee.Thermostat{t},
+			"status":         ecobee.Status{},
+		})
+
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeStatus(w, http.StatusInternalServerError, ecobee.StatusProcessingError, err.Error())
+			return
+		}
+		var req updateRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeStatus(w, http.StatusInternalServerError, ecobee.StatusSerializationError, err.Error())
+			return
+		}
+		if !selects(req.Selection) {
+			writeStatus(w, http.StatusInternalServerError, ecobee.StatusInvalidSelection, "Invalid selection.")
+			return
+		}
+		s.mu.Lock()
+		err = s.sim.update(req)
+		s.mu.Unlock()
+		if err != nil {
+			writeStatus(w, http.StatusInternalServerError, ecobee.StatusFunctionError, err.Error())
+			return
+		}
+		writeStatus(w, http.StatusOK, ecobee.StatusSuccess, "")
+
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, ecobee.StatusInvalidRequestFormat, "unsupported method")
+	}
+}
+
+func (s *Server) handleThermostatSummary(w http.ResponseWriter, r *http.Request) {
+	var req ecobee.GetThermostatSummaryRequest
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("json")), &req); err != nil {
+		writeStatus(w, http.StatusInternalServerError, ecobee.StatusSerializationError, err.Error())
+		return
+	}
+	if !selects(req.Selection) {
+		writeJSON(w, http.StatusOK, map[string]any{"thermostatCount": 0, "status": ecobee.Status{}})
+		return
+	}
+	s.mu.Lock()
+	revision, status := s.sim.summary()
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"thermostatCount": 1,
+		"revisionList":    []string{revision},
+		"statusList":      []string{status},
+		"status":          ecobee.Status{},
+	})
+}
+
+// selects reports whether the selection includes the fake thermostat.
+func selects(sel ecobee.Selection) bool {
+	switch sel.SelectionType {
+	case "registered":
+		return true
+	case "thermostats":
+		for _, id := range strings.Split(sel.SelectionMatch, ",") {
+			if strings.TrimSpace(id) == ThermostatID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// updateRequest mirrors ecobee.UpdateThermostatRequest, deferring decoding of
+// function parameters until the function type is known.
+type updateRequest struct {
+	Selection  ecobee.Selection         `json:"selection"`
+	Thermostat *ecobee.ThermostatUpdate `json:"thermostat"`
+	Functions  []struct {
+		Type   string          `json:"type"`
+		Params json.RawMessage `json:"params"`
+	} `json:"functions"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("ecobeetest: failed to write response: %s", err)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, httpStatus, code int, message string) {
+	writeJSON(w, httpStatus, map[string]any{
+		"status": ecobee.Status{Code: code, Message: message},
+	})
+}
+
+func writeAuthError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]any{
+		"error":             code,
+		"error_description": description,
+	})
+}
@@ -0,0 +1,45 @@
+package ecobeetest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+func TestServer(t *testing.T) {
+	sim := NewServer()
+	defer sim.Close()
+	opts := []ecobee.ClientOption{ecobee.WithBaseURL(sim.URL), ecobee.NonInteractive()}
+	cachePath := filepath.Join(t.TempDir(), "cred-cache")
+
+	// Without credentials, requests fail:
+	if _, err := ecobee.NewClient("test", cachePath, opts...).GetThermostatContext(context.Background(), ThermostatID); err == nil {
+		t.Fatal("GetThermostatContext without authorization succeeded")
+	}
+
+	pin, err := ecobee.Authorize("test", opts...)
+	if err != nil {
+		t.Fatalf("Authorize: %s", err)
+	}
+	if err := ecobee.SaveToken("test", cachePath, pin.Code, opts...); err != nil {
+		t.Fatalf("SaveToken: %s", err)
+	}
+	thermostat, err := ecobee.NewClient("test", cachePath, opts...).GetThermostatContext(context.Background(), ThermostatID)
+	if err != nil {
+		t.Fatalf("GetThermostatContext: %s", err)
+	}
+	if thermostat.Identifier != ThermostatID {
+		t.Errorf("identifier = %s; want %s", thermostat.Identifier, ThermostatID)
+	}
+	if len(thermostat.RemoteSensors) == 0 {
+		t.Error("no remote sensors")
+	}
+	if len(thermostat.Weather.Forecasts) == 0 {
+		t.Error("no weather forecasts")
+	}
+	if len(thermostat.ExtendedRuntime.ActualTemperature) != 3 {
+		t.Errorf("got %d extended runtime readings; want 3", len(thermostat.ExtendedRuntime.ActualTemperature))
+	}
+}
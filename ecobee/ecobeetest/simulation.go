@@ -0,0 +1,730 @@
+package ecobeetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const (
+	ecobeeTimeFormat = "2006-01-02 15:04:05"
+	ecobeeDateFormat = "2006-01-02"
+	runtimeInterval  = 5 * time.Minute
+)
+
+// climate is one of the synthetic thermostat's comfort settings.
+type climate struct {
+	ref, name          string
+	heatTemp, coolTemp int // tenths of a degree F
+	occupied           bool
+}
+
+var climates = []climate{
+	{ref: "home", name: "Home", heatTemp: 690, coolTemp: 760, occupied: true},
+	{ref: "away", name: "Away", heatTemp: 620, coolTemp: 820},
+	{ref: "sleep", name: "Sleep", heatTemp: 660, coolTemp: 780, occupied: true},
+}
+
+func climateByRef(ref string) climate {
+	for _, c := range climates {
+		if c.ref == ref {
+			return c
+		}
+	}
+	return climates[0]
+}
+
+// sensor describes one of the synthetic thermostat's sensors.
+type sensor struct {
+	id, name, sensorType, code string
+	tempOffset                 float64 // degrees F relative to the thermostat
+	hasHumidity                bool
+	hasAirQuality              bool
+	occupied                   func(t time.Time) bool
+}
+
+var sensors = []sensor{
+	{
+		id: "ei:0", name: "Thermostat", sensorType: "ecobee3",
+		hasHumidity: true, hasAirQuality: true,
+		occupied: func(t time.Time) bool {
+			h := t.Hour()
+			return (h >= 7 && h < 9) || (h >= 17 && h < 22)
+		},
+	},
+	{
+		id: "rs:100", name: "Bedroom", sensorType: "ecobee3_remote_sensor", code: "BDRM",
+		tempOffset: -1.5,
+		occupied: func(t time.Time) bool {
+			h := t.Hour()
+			return h >= 22 || h < 7
+		},
+	},
+	{
+		id: "rs:101", name: "Office", sensorType: "ecobee3_remote_sensor", code: "OFFC",
+		tempOffset: 0.8,
+		occupied: func(t time.Time) bool {
+			h := t.Hour()
+			weekday := t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+			return weekday && ((h >= 9 && h < 12) || (h >= 13 && h < 17))
+		},
+	},
+}
+
+// simulation models the fake thermostat. Readings are derived from the
+// current time, so they evolve smoothly and consistently between requests;
+// state changed via the API (holds, messages) is stored here. Sent messages
+// are served back as alerts, alongside a filter reminder.
+type simulation struct {
+	now      func() time.Time
+	started  time.Time
+	hold     *ecobee.Event
+	settings ecobee.Settings
+	updated  *ecobee.Program // the program, once it has been updated via the API
+	alerts   []ecobee.Alert
+	alertSeq int
+	names    map[string]string // sensors renamed via the API, by sensor ID
+	revision int               // incremented whenever the thermostat's configuration changes
+}
+
+func newSimulation(now func() time.Time) *simulation {
+	s := &simulation{now: now, started: now(), settings: defaultSettings}
+	s.addAlert(ecobee.Alert{
+		Severity:         "low",
+		Text:             "Your furnace filter is due to be changed.",
+		AlertNumber:      9256,
+		AlertType:        "alert",
+		Reminder:         "furnaceFilterReminder",
+		ShowIdt:          true,
+		ShowWeb:          true,
+		NotificationType: "filter",
+	})
+	return s
+}
+
+// addAlert adds an alert raised at the current time.
+func (s *simulation) addAlert(a ecobee.Alert) {
+	now := s.now()
+	s.alertSeq++
+	a.AcknowledgeRef = fmt.Sprintf("%s$%d", ThermostatID, s.alertSeq)
+	a.Date = now.Format(ecobeeDateFormat)
+	a.Time = now.Format("15:04:05")
+	a.ThermostatIdentifier = ThermostatID
+	s.alerts = append(s.alerts, a)
+}
+
+// sensorName returns the sensor's name, as renamed via the API.
+func (s *simulation) sensorName(sn sensor) string {
+	if name, ok := s.names[sn.id]; ok {
+		return name
+	}
+	return sn.name
+}
+
+// renameSensor applies an updateSensor function.
+func (s *simulation) renameSensor(p ecobee.UpdateSensorParams) error {
+	for _, sn := range sensors {
+		if sn.id != p.DeviceID {
+			continue
+		}
+		if p.SensorID != "1" {
+			return fmt.Errorf("sensor %q has no capability %q", p.DeviceID, p.SensorID)
+		}
+		if s.names == nil {
+			s.names = make(map[string]string)
+		}
+		s.names[sn.id] = p.Name
+		if s.updated != nil {
+			for i := range s.updated.Climates {
+				for j, cs := range s.updated.Climates[i].Sensors {
+					if strings.HasPrefix(cs.ID, sn.id+":") {
+						s.updated.Climates[i].Sensors[j].Name = p.Name
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no sensor with deviceId %q", p.DeviceID)
+}
+
+// defaultSettings describes a two-stage gas furnace with single-stage air
+// conditioning.
+var defaultSettings = ecobee.Settings{
+	HvacMode:                      "auto",
+	Vent:                          "off",
+	CoolStages:                    1,
+	HeatStages:                    2,
+	HasForcedAir:                  true,
+	HumidifierMode:                "off",
+	Humidity:                      "36",
+	CompressorProtectionMinTime:   300,
+	CompressorProtectionMinTemp:   -100,
+	Stage1HeatingDifferentialTemp: 5,
+	Stage1CoolingDifferentialTemp: 5,
+	Stage1HeatingDissipationTime:  31,
+	Stage1CoolingDissipationTime:  31,
+	FanMinOnTime:                  10,
+	HeatCoolMinDelta:              50,
+	HoldAction:                    "nextPeriod",
+	DehumidifierMode:              "on",
+	DehumidifierLevel:             60,
+	DehumidifyWithAC:              true,
+	DehumidifyOvercoolOffset:      20,
+	AutoHeatCoolFeatureEnabled:    true,
+	HeatMinTemp:                   450,
+	HeatMaxTemp:                   1200,
+	CoolMinTemp:                   -100,
+	CoolMaxTemp:                   1200,
+	HeatRangeHigh:                 790,
+	HeatRangeLow:                  450,
+	CoolRangeHigh:                 920,
+	CoolRangeLow:                  650,
+	AuxMaxOutdoorTemp:             700,
+	AutoAway:                      true,
+	FollowMeComfort:               true,
+	MonthsBetweenService:          6,
+}
+
+// scheduledClimate returns the program's climate at the given (local) time.
+func scheduledClimate(t time.Time) climate {
+	h := t.Hour()
+	weekday := t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+	switch {
+	case h >= 22 || h < 6:
+		return climateByRef("sleep")
+	case weekday && h >= 9 && h < 17:
+		return climateByRef("away")
+	default:
+		return climateByRef("home")
+	}
+}
+
+// setPoints returns the heat and cool set points, in tenths of a degree F, in
+// effect at t, accounting for any running hold.
+func (s *simulation) setPoints(t time.Time) (int, int) {
+	if s.hold != nil {
+		return s.hold.HeatHoldTemp, s.hold.CoolHoldTemp
+	}
+	c := scheduledClimate(t)
+	return c.heatTemp, c.coolTemp
+}
+
+// outdoorTempF follows a daily cycle, coolest around 3am and warmest around
+// 3pm, plus a slower multi-day swing.
+func outdoorTempF(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	days := float64(t.Unix()) / 86400
+	return 48 + 14*math.Sin(2*math.Pi*(hour-9)/24) + 8*math.Sin(2*math.Pi*days/5)
+}
+
+func outdoorHumidity(t time.Time) int {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	return int(math.Round(65 - 20*math.Sin(2*math.Pi*(hour-9)/24)))
+}
+
+// indoorTempF oscillates around the active set point as equipment cycles.
+func (s *simulation) indoorTempF(t time.Time) float64 {
+	heat, cool := s.setPoints(t)
+	target := float64(heat)/10 + 0.5
+	if outdoorTempF(t) > float64(cool)/10 {
+		target = float64(cool)/10 - 0.5
+	}
+	minutes := float64(t.Unix()) / 60
+	return target + 0.6*math.Sin(2*math.Pi*minutes/37)
+}
+
+func indoorHumidity(t time.Time) int {
+	days := float64(t.Unix()) / 86400
+	return int(math.Round(40 + 6*math.Sin(2*math.Pi*days/3)))
+}
+
+// sensorOccupants returns the number of sensors detecting occupancy at t.
+func sensorOccupants(t time.Time) int {
+	n := 0
+	for _, sn := range sensors {
+		if sn.occupied(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// runtimeSeconds returns heating, cooling and fan runtime, in seconds, for
+// the runtime interval starting at t.
+func (s *simulation) runtimeSeconds(t time.Time) (heat, cool, fan int) {
+	heatSP, coolSP := s.setPoints(t)
+	outdoor := outdoorTempF(t)
+	heatDemand := clamp((float64(heatSP)/10-outdoor)/40, 0, 1)
+	coolDemand := clamp((outdoor-float64(coolSP)/10)/15, 0, 1)
+	heat = int(heatDemand * runtimeInterval.Seconds())
+	cool = int(coolDemand * runtimeInterval.Seconds())
+	fan = max(heat, cool)
+	if s.hold != nil && s.hold.Fan == "on" {
+		fan = int(runtimeInterval.Seconds())
+	}
+	return heat, cool, fan
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+func tenths(f float64) int {
+	return int(math.Round(f * 10))
+}
+
+// expireHold clears a hold whose end time has passed.
+func (s *simulation) expireHold(now time.Time) {
+	if s.hold == nil || s.hold.EndDate == "" {
+		return
+	}
+	end, err := time.ParseInLocation(ecobeeTimeFormat, s.hold.EndDate+" "+s.hold.EndTime, time.Local)
+	if err == nil && now.After(end) {
+		s.hold = nil
+		s.revision++
+	}
+}
+
+// thermostat renders the thermostat, including the sections requested by the
+// selection, as of the current time.
+func (s *simulation) thermostat(sel ecobee.Selection) ecobee.Thermostat {
+	now := s.now()
+	s.expireHold(now)
+	utcNow := now.UTC()
+
+	t := ecobee.Thermostat{
+		Identifier:     ThermostatID,
+		Name:           "Simulated Thermostat",
+		ThermostatRev:  s.thermostatRev(),
+		IsRegistered:   true,
+		ModelNumber:    "athenaSmart",
+		Brand:          "ecobee",
+		LastModified:   s.started.UTC().Format(ecobeeTimeFormat),
+		ThermostatTime: now.Format(ecobeeTimeFormat),
+		UtcTime:        utcNow.Format(ecobeeTimeFormat),
+	}
+	if sel.IncludeRuntime {
+		t.Runtime = s.runtime(now)
+	}
+	if sel.IncludeExtendedRuntime {
+		t.ExtendedRuntime = s.extendedRuntime(now)
+	}
+	if sel.IncludeEvents {
+		t.Events = s.events()
+	}
+	if sel.IncludeAlerts {
+		t.Alerts = append([]ecobee.Alert{}, s.alerts...)
+	}
+	if sel.IncludeSettings {
+		t.Settings = s.settings
+	}
+	if sel.IncludeProgram {
+		t.Program = s.program(now)
+	}
+	if sel.IncludeSensors {
+		t.RemoteSensors = s.remoteSensors(now)
+	}
+	if sel.IncludeWeather {
+		t.Weather = s.weather(now)
+	}
+	if sel.IncludeElectricity {
+		t.Electricity = electricity(now)
+	}
+	if sel.IncludeUtility {
+		t.Utility = ecobee.Utility{Name: "Simulated Power & Light", Web: "https://example.com"}
+	}
+	if sel.IncludeVersion {
+		t.Version = ecobee.Version{ThermostatFirmwareVersion: "4.8.7.132"}
+	}
+	if sel.IncludeLocation {
+		zone, offset := now.Zone()
+		if name := now.Location().String(); name != "Local" {
+			zone = name
+		}
+		t.Location = ecobee.Location{
+			TimeZoneOffsetMinutes: offset / 60,
+			TimeZone:              zone,
+			City:                  "Ann Arbor",
+			ProvinceState:         "MI",
+			Country:               "USA",
+			PostalCode:            "48104",
+			MapCoordinates:        "42.2808, -83.7430",
+		}
+	}
+	if sel.IncludeDevice {
+		t.Devices = []ecobee.Device{{
+			DeviceID: 0,
+			Sensors: []ecobee.DeviceSensor{
+				{Name: "Thermostat", Type: "temperature", Usage: "indoor", Zone: 1, SensorID: 1},
+				{Name: "Thermostat", Type: "humidity", Usage: "indoor", Zone: 1, SensorID: 2},
+			},
+			Outputs: []ecobee.Output{
+				{Name: "W1", Type: "heat1", OutputID: 1, Zone: 1, SendUpdate: true},
+				{Name: "W2", Type: "heat2", OutputID: 2, Zone: 1, SendUpdate: true},
+				{Name: "Y1", Type: "compressor1", OutputID: 3, Zone: 1, SendUpdate: true},
+				{Name: "G", Type: "fan", OutputID: 4, Zone: 1, SendUpdate: true},
+			},
+		}}
+	}
+	return t
+}
+
+func (s *simulation) thermostatRev() string {
+	return s.started.Add(time.Duration(s.revision) * time.Second).UTC().Format("060102150405")
+}
+
+func (s *simulation) runtime(now time.Time) ecobee.Runtime {
+	heat, cool := s.setPoints(now)
+	statusTime := now.UTC().Truncate(3 * time.Minute)
+	occupied := 0
+	for _, sn := range sensors {
+		if sn.occupied(now) {
+			occupied++
+		}
+	}
+	return ecobee.Runtime{
+		RuntimeRev:         statusTime.Format("060102150405"),
+		Connected:          true,
+		FirstConnected:     "2020-01-01 00:00:00",
+		ConnectDateTime:    s.started.UTC().Format(ecobeeTimeFormat),
+		DisconnectDateTime: s.started.UTC().Add(-time.Minute).Format(ecobeeTimeFormat),
+		LastModified:       statusTime.Format(ecobeeTimeFormat),
+		LastStatusModified: statusTime.Format(ecobeeTimeFormat),
+		RuntimeDate:        now.UTC().Format(ecobeeDateFormat),
+		RuntimeInterval:    intervalOfDay(now.UTC()),
+		ActualTemperature:  tenths(s.indoorTempF(now)),
+		ActualHumidity:     indoorHumidity(now),
+		DesiredHeat:        heat,
+		DesiredCool:        cool,
+		DesiredHumidity:    36,
+		DesiredDehumidity:  60,
+		DesiredFanMode:     "auto",
+		ActualAQAccuracy:   3,
+		ActualAQScore:      40 + 15*occupied,
+		ActualCO2:          550 + 180*occupied,
+		ActualVOC:          300 + 120*occupied,
+		DesiredHeatRange:   []int{450, 790},
+		DesiredCoolRange:   []int{650, 920},
+	}
+}
+
+func intervalOfDay(t time.Time) int {
+	return (t.Hour()*60 + t.Minute()) / 5
+}
+
+// extendedRuntime covers the last three complete 5-minute intervals.
+// LastReadingTimestamp refers to the middle one.
+func (s *simulation) extendedRuntime(now time.Time) ecobee.ExtendedRuntime {
+	base := now.UTC().Truncate(runtimeInterval).Add(-2 * runtimeInterval)
+	er := ecobee.ExtendedRuntime{
+		LastReadingTimestamp: base.Format(ecobeeTimeFormat),
+		RuntimeDate:          base.Format(ecobeeDateFormat),
+		RuntimeInterval:      intervalOfDay(base),
+	}
+	for i := -1; i <= 1; i++ {
+		t := base.Add(time.Duration(i) * runtimeInterval).In(now.Location())
+		heatSP, coolSP := s.setPoints(t)
+		heat, cool, fan := s.runtimeSeconds(t)
+		mode := "heatOff"
+		if heat > 0 {
+			mode = "heatStage10n"
+		} else if cool > 0 {
+			mode = "compressorCoolStage10n"
+		}
+		er.ActualTemperature = append(er.ActualTemperature, tenths(s.indoorTempF(t)))
+		er.ActualHumidity = append(er.ActualHumidity, indoorHumidity(t))
+		er.DesiredHeat = append(er.DesiredHeat, heatSP)
+		er.DesiredCool = append(er.DesiredCool, coolSP)
+		er.DesiredHumidity = append(er.DesiredHumidity, 36)
+		er.DesiredDehumidity = append(er.DesiredDehumidity, 60)
+		er.DmOffset = append(er.DmOffset, 0)
+		er.HvacMode = append(er.HvacMode, mode)
+		er.HeatPump1 = append(er.HeatPump1, 0)
+		er.HeatPump2 = append(er.HeatPump2, 0)
+		er.AuxHeat1 = append(er.AuxHeat1, heat)
+		er.AuxHeat2 = append(er.AuxHeat2, heat/3)
+		er.AuxHeat3 = append(er.AuxHeat3, 0)
+		er.Cool1 = append(er.Cool1, cool)
+		er.Cool2 = append(er.Cool2, 0)
+		er.Fan = append(er.Fan, fan)
+		er.Humidifier = append(er.Humidifier, 0)
+		er.Dehumidifier = append(er.Dehumidifier, 0)
+		er.Economizer = append(er.Economizer, 0)
+		er.Ventilator = append(er.Ventilator, fan/5)
+	}
+	// The bill accrues through the month, at about $3 a day:
+	er.CurrentElectricityBill = 3 * now.Day()
+	er.ProjectedElectricityBill = 3 * time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return er
+}
+
+func (s *simulation) events() []ecobee.Event {
+	if s.hold == nil {
+		return []ecobee.Event{}
+	}
+	return []ecobee.Event{*s.hold}
+}
+
+func (s *simulation) program(now time.Time) ecobee.Program {
+	if s.updated != nil {
+		p := *s.updated
+		// The schedule starts on Monday, in half-hour slots.
+		day := (int(now.Weekday()) + 6) % 7
+		slot := now.Hour()*2 + now.Minute()/30
+		if day < len(p.Schedule) && slot < len(p.Schedule[day]) {
+			p.CurrentClimateRef = p.Schedule[day][slot]
+		}
+		return p
+	}
+	p := ecobee.Program{CurrentClimateRef: scheduledClimate(now).ref}
+	// The schedule starts on Monday, in half-hour slots.
+	monday := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local)
+	for d := 0; d < 7; d++ {
+		var day []string
+		for slot := 0; slot < 48; slot++ {
+			t := monday.AddDate(0, 0, d).Add(time.Duration(slot) * 30 * time.Minute)
+			day = append(day, scheduledClimate(t).ref)
+		}
+		p.Schedule = append(p.Schedule, day)
+	}
+	for _, c := range climates {
+		ec := ecobee.Climate{
+			Name:       c.name,
+			ClimateRef: c.ref,
+			IsOccupied: c.occupied,
+			CoolFan:    "auto",
+			HeatFan:    "auto",
+			Vent:       "off",
+			Owner:      "system",
+			Type:       "program",
+			CoolTemp:   c.coolTemp,
+			HeatTemp:   c.heatTemp,
+		}
+		for _, sn := range sensors {
+			if c.ref == "sleep" && sn.id == "rs:101" {
+				continue // the office isn't considered overnight
+			}
+			ec.Sensors = append(ec.Sensors, ecobee.RemoteSensor{ID: sn.id + ":1", Name: s.sensorName(sn)})
+		}
+		p.Climates = append(p.Climates, ec)
+	}
+	return p
+}
+
+func (s *simulation) remoteSensors(now time.Time) []ecobee.RemoteSensor {
+	indoor := s.indoorTempF(now)
+	var rs []ecobee.RemoteSensor
+	for _, sn := range sensors {
+		r := ecobee.RemoteSensor{
+			ID:    sn.id,
+			Name:  s.sensorName(sn),
+			Type:  sn.sensorType,
+			Code:  sn.code,
+			InUse: true,
+			Capability: []ecobee.RemoteSensorCapability{
+				{ID: "1", Type: "temperature", Value: fmt.Sprintf("%d", tenths(indoor+sn.tempOffset))},
+			},
+		}
+		if sn.hasHumidity {
+			r.Capability = append(r.Capability, ecobee.RemoteSensorCapability{
+				ID: "2", Type: "humidity", Value: fmt.Sprintf("%d", indoorHumidity(now)),
+			})
+		}
+		if sn.hasAirQuality {
+			r.Capability = append(r.Capability,
+				ecobee.RemoteSensorCapability{ID: "4", Type: "airPressure", Value: fmt.Sprintf("%d", 101300+int(300*math.Sin(float64(now.Unix())/90000)))},
+				ecobee.RemoteSensorCapability{ID: "5", Type: "co2PPM", Value: fmt.Sprintf("%d", 450+20*sensorOccupants(now))},
+				ecobee.RemoteSensorCapability{ID: "6", Type: "vocPPM", Value: fmt.Sprintf("%d", 120+15*sensorOccupants(now))},
+				ecobee.RemoteSensorCapability{ID: "7", Type: "airQuality", Value: "unknown"},
+			)
+		}
+		r.Capability = append(r.Capability, ecobee.RemoteSensorCapability{
+			ID: "3", Type: "occupancy", Value: fmt.Sprintf("%t", sn.occupied(now)),
+		})
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// electricity reports month-to-date usage, split between off-peak and
+// on-peak tiers.
+func electricity(now time.Time) ecobee.Electricity {
+	days := float64(now.Day())
+	offPeak, onPeak := 18*days, 6*days // kWh
+	return ecobee.Electricity{Devices: []ecobee.ElectricityDevice{{
+		LastUpdate: now.UTC().Truncate(time.Hour).Format(ecobeeTimeFormat),
+		Tiers: []ecobee.ElectricityTier{
+			{Name: "offPeak", Consumption: fmt.Sprintf("%.1f", offPeak), Cost: fmt.Sprintf("%.2f", offPeak*0.09)},
+			{Name: "onPeak", Consumption: fmt.Sprintf("%.1f", onPeak), Cost: fmt.Sprintf("%.2f", onPeak*0.21)},
+		},
+	}}}
+}
+
+// weather includes current conditions followed by forecasts at 12-hour
+// intervals, as the real API does.
+func (s *simulation) weather(now time.Time) ecobee.Weather {
+	observed := now.Truncate(15 * time.Minute)
+	w := ecobee.Weather{
+		Timestamp:      observed.UTC().Format(ecobeeTimeFormat),
+		WeatherStation: "SIM-KXYZ",
+	}
+	for i := 0; i < 5; i++ {
+		t := observed.Add(time.Duration(i) * 12 * time.Hour)
+		temp := outdoorTempF(t)
+		rh := outdoorHumidity(t)
+		dewpoint := temp - float64(100-rh)*9/25 // rough approximation
+		windSpeed := int(math.Round(6 + 5*math.Sin(float64(t.Unix())/20000)))
+		pop := int(clamp(float64(rh-50)*2, 0, 100))
+		symbol, condition, sky := 1, "Partly Cloudy", 4
+		if pop > 40 {
+			symbol, condition, sky = 6, "Rain", 8
+		} else if rh < 55 {
+			symbol, condition, sky = 0, "Sunny", 1
+		}
+		w.Forecasts = append(w.Forecasts, ecobee.WeatherForecast{
+			WeatherSymbol:    symbol,
+			DateTime:         t.Format(ecobeeTimeFormat),
+			Condition:        condition,
+			Temperature:      tenths(temp),
+			Pressure:         1013 + int(math.Round(6*math.Sin(float64(t.Unix())/90000))),
+			RelativeHumidity: rh,
+			Dewpoint:         tenths(dewpoint),
+			Visibility:       16093,
+			WindSpeed:        windSpeed,
+			WindGust:         windSpeed + 7,
+			WindDirection:    "NW",
+			WindBearing:      315,
+			Pop:              pop,
+			TempHigh:         tenths(temp + 6),
+			TempLow:          tenths(temp - 9),
+			Sky:              sky,
+		})
+	}
+	return w
+}
+
+// summary returns the thermostat's revision and equipment status strings,
+// in the formats used by the thermostatSummary endpoint.
+func (s *simulation) summary() (string, string) {
+	now := s.now()
+	s.expireHold(now)
+	runtimeRev := now.UTC().Truncate(3 * time.Minute).Format("060102150405")
+	intervalRev := now.UTC().Truncate(runtimeInterval).Format("060102150405")
+	revision := fmt.Sprintf("%s:%s:true:%s:%s:%s:%s",
+		ThermostatID, "Simulated Thermostat", s.thermostatRev(), s.thermostatRev(), runtimeRev, intervalRev)
+
+	var equipment []string
+	heat, cool, fan := s.runtimeSeconds(now.Truncate(runtimeInterval))
+	if heat > 0 {
+		equipment = append(equipment, "auxHeat1")
+	}
+	if cool > 0 {
+		equipment = append(equipment, "compCool1")
+	}
+	if fan > 0 {
+		equipment = append(equipment, "fan")
+	}
+	return revision, ThermostatID + ":" + strings.Join(equipment, ",")
+}
+
+// update applies the functions in an UpdateThermostat request.
+func (s *simulation) update(req updateRequest) error {
+	if req.Thermostat != nil && req.Thermostat.Program != nil {
+		p := *req.Thermostat.Program
+		if len(p.Schedule) != 7 || len(p.Climates) == 0 {
+			return fmt.Errorf("program must have a 7-day schedule and at least one climate")
+		}
+		s.updated = &p
+		s.revision++
+	}
+	for _, f := range req.Functions {
+		switch f.Type {
+		case "setHold":
+			var p ecobee.SetHoldParams
+			if err := json.Unmarshal(f.Params, &p); err != nil {
+				return fmt.Errorf("invalid setHold params: %w", err)
+			}
+			heat, cool := p.HeatHoldTemp, p.CoolHoldTemp
+			if !p.Event.IsTemperatureAbsolute && !p.Event.IsTemperatureRelative {
+				// Fan-only holds keep the current set points.
+				heat, cool = s.setPoints(s.now())
+			}
+			now := s.now()
+			s.hold = &ecobee.Event{
+				Type:           "hold",
+				Name:           "auto",
+				Running:        true,
+				StartDate:      now.Format(ecobeeDateFormat),
+				StartTime:      now.Format("15:04:05"),
+				EndDate:        p.EndDate,
+				EndTime:        p.EndTime,
+				IsOccupied:     true,
+				HeatHoldTemp:   heat,
+				CoolHoldTemp:   cool,
+				Fan:            p.Event.Fan,
+				HoldClimateRef: p.HoldClimateRef,
+			}
+		case "resumeProgram":
+			s.hold = nil
+		case "sendMessage":
+			var p ecobee.SendMessageParams
+			if err := json.Unmarshal(f.Params, &p); err != nil {
+				return fmt.Errorf("invalid sendMessage params: %w", err)
+			}
+			s.addAlert(ecobee.Alert{
+				Severity:         "normal",
+				Text:             p.Text,
+				AlertType:        "alert",
+				ShowIdt:          true,
+				ShowWeb:          true,
+				NotificationType: "message",
+			})
+		case "acknowledge":
+			var p ecobee.AcknowledgeParams
+			if err := json.Unmarshal(f.Params, &p); err != nil {
+				return fmt.Errorf("invalid acknowledge params: %w", err)
+			}
+			if err := s.acknowledge(p); err != nil {
+				return err
+			}
+		case "updateSensor":
+			var p ecobee.UpdateSensorParams
+			if err := json.Unmarshal(f.Params, &p); err != nil {
+				return fmt.Errorf("invalid updateSensor params: %w", err)
+			}
+			if err := s.renameSensor(p); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported function %q", f.Type)
+		}
+		s.revision++
+	}
+	return nil
+}
+
+// acknowledge applies an acknowledgement to the alert it references.
+// Accepted and declined alerts are cleared.
+func (s *simulation) acknowledge(p ecobee.AcknowledgeParams) error {
+	for i, a := range s.alerts {
+		if a.AcknowledgeRef != p.AckRef {
+			continue
+		}
+		switch p.AckType {
+		case ecobee.AckTypeAccept, ecobee.AckTypeDecline:
+			s.alerts = append(s.alerts[:i], s.alerts[i+1:]...)
+		case ecobee.AckTypeDefer, ecobee.AckTypeUnacknowledged:
+			s.alerts[i].Acknowledgement = p.AckType
+			s.alerts[i].RemindMeLater = p.RemindMeLater
+		default:
+			return fmt.Errorf("invalid ackType %q", p.AckType)
+		}
+		return nil
+	}
+	return fmt.Errorf("no alert with ackRef %q", p.AckRef)
+}
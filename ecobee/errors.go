@@ -0,0 +1,134 @@
+package ecobee
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Status codes returned by the Ecobee API in a response's status object.
+// See https://www.ecobee.com/home/developer/api/documentation/v1/general/responseCodes.shtml
+const (
+	StatusSuccess              = 0
+	StatusAuthenticationFailed = 1
+	StatusNotAuthorized        = 2
+	StatusProcessingError      = 3
+	StatusSerializationError   = 4
+	StatusInvalidRequestFormat = 5
+	StatusTooManyThermostats   = 6
+	StatusValidationError      = 7
+	StatusInvalidFunction      = 8
+	StatusInvalidSelection     = 9
+	StatusInvalidPage          = 10
+	StatusFunctionError        = 11
+	StatusPostNotSupported     = 12
+	StatusGetNotSupported      = 13
+	StatusAuthTokenExpired     = 14
+	StatusDuplicateData        = 15
+	StatusInvalidToken         = 16
+)
+
+// APIError is returned when the Ecobee API responds with an error, either as
+// a non-200 HTTP status or as a non-zero status code in the response body.
+type APIError struct {
+	HTTPStatus int
+	Code       int // Ecobee status code; 0 if the response didn't include one
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == StatusSuccess {
+		return fmt.Sprintf("invalid server response: %d %s", e.HTTPStatus, http.StatusText(e.HTTPStatus))
+	}
+	return fmt.Sprintf("api error %d (HTTP %d): %s", e.Code, e.HTTPStatus, e.Message)
+}
+
+// Class classifies the error; see ErrorClass.
+func (e *APIError) Class() ErrorClass {
+	switch e.Code {
+	case StatusProcessingError, StatusAuthTokenExpired:
+		// Ecobee asks that processing errors be retried; an expired access
+		// token is refreshed by the next request.
+		return ErrorClassRetryable
+	case StatusAuthenticationFailed, StatusNotAuthorized, StatusInvalidToken:
+		return ErrorClassAuth
+	case StatusSuccess:
+		if e.HTTPStatus >= 500 || e.HTTPStatus == http.StatusTooManyRequests {
+			return ErrorClassRetryable
+		}
+		return ErrorClassPermanent
+	default:
+		return ErrorClassPermanent
+	}
+}
+
+// newAPIError builds an APIError from a non-200 response, including the
+// status object from its body if there is one.
+func newAPIError(httpStatus int, body []byte) *APIError {
+	e := &APIError{HTTPStatus: httpStatus}
+	var r struct {
+		Status Status `json:"status"`
+	}
+	if json.Unmarshal(body, &r) == nil {
+		e.Code = r.Status.Code
+		e.Message = r.Status.Message
+	}
+	return e
+}
+
+// ErrorClass describes how calling code should react to an error.
+type ErrorClass int
+
+const (
+	// ErrorClassRetryable errors are transient; the request may succeed if
+	// retried later.
+	ErrorClassRetryable ErrorClass = iota
+	// ErrorClassAuth errors require the application to be (re-)authorized.
+	ErrorClassAuth
+	// ErrorClassPermanent errors will recur if the request is retried.
+	ErrorClassPermanent
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassRetryable:
+		return "retryable"
+	case ErrorClassAuth:
+		return "auth"
+	case ErrorClassPermanent:
+		return "permanent"
+	}
+	return fmt.Sprintf("ErrorClass(%d)", int(c))
+}
+
+// Classify returns the ErrorClass of an error returned by this package.
+// Errors not originating from the Ecobee API (e.g. network errors) are
+// considered retryable.
+func Classify(err error) ErrorClass {
+	var apiErr *APIError
+	var authErr *AuthError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.Class()
+	case errors.As(err, &authErr):
+		if authErr.Revoked() {
+			return ErrorClassAuth
+		}
+		if authErr.StatusCode >= 500 || authErr.StatusCode == http.StatusTooManyRequests || authErr.Code == "slow_down" {
+			return ErrorClassRetryable
+		}
+		return ErrorClassPermanent
+	case errors.Is(err, ErrAuthorizationRequired), errors.Is(err, ErrCredCacheCorrupt):
+		return ErrorClassAuth
+	case errors.Is(err, context.Canceled):
+		return ErrorClassPermanent
+	}
+	return ErrorClassRetryable
+}
+
+// IsRetryable reports whether err is transient, per Classify.
+func IsRetryable(err error) bool {
+	return Classify(err) == ErrorClassRetryable
+}
@@ -16,9 +16,12 @@ package ecobee
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -26,10 +29,16 @@ import (
 	"github.com/golang/glog"
 )
 
-const thermostatAPIURL = `https://api.ecobee.com/1/thermostat`
-const thermostatSummaryURL = `https://api.ecobee.com/1/thermostatSummary`
+const thermostatAPIPath = `/1/thermostat`
+const thermostatSummaryPath = `/1/thermostatSummary`
 
 func (c *Client) UpdateThermostat(utr UpdateThermostatRequest) error {
+	return c.UpdateThermostatContext(context.Background(), utr)
+}
+
+// UpdateThermostatContext is like UpdateThermostat, but the request is bound
+// to ctx.
+func (c *Client) UpdateThermostatContext(ctx context.Context, utr UpdateThermostatRequest) error {
 	j, err := json.Marshal(&utr)
 	if err != nil {
 		return fmt.Errorf("error marshaling json: %v", err)
@@ -38,16 +47,21 @@ func (c *Client) UpdateThermostat(utr UpdateThermostatRequest) error {
 	glog.V(1).Infof("UpdateThermostat request: %s", j)
 
 	// everything below here can be factored out into a common POST func
-	resp, err := c.Post(thermostatAPIURL, "application/json", bytes.NewReader(j))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+thermostatAPIPath, bytes.NewReader(j))
 	if err != nil {
-		return fmt.Errorf("error on post request: %v", err)
+		return fmt.Errorf("error building post request: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("invalid server response: %v", resp.Status)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("error on post request: %w", err)
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return c.apiError(newAPIError(resp.StatusCode, body))
+	}
 	if err != nil {
 		return fmt.Errorf("error reading body: %v", err)
 	}
@@ -59,13 +73,26 @@ func (c *Client) UpdateThermostat(utr UpdateThermostatRequest) error {
 
 	glog.V(1).Infof("UpdateThermostat response: %+v", s)
 
-	if s.Status.Code == 0 {
+	if s.Status.Code == StatusSuccess {
 		return nil
 	}
-	return fmt.Errorf("API error: %s", s.Status.Message)
+	return c.apiError(&APIError{HTTPStatus: resp.StatusCode, Code: s.Status.Code, Message: s.Status.Message})
 }
 
 func (c *Client) GetThermostat(thermostatID string) (*Thermostat, error) {
+	return c.GetThermostatContext(context.Background(), thermostatID)
+}
+
+// GetThermostatContext is like GetThermostat, but the request is bound to ctx.
+func (c *Client) GetThermostatContext(ctx context.Context, thermostatID string) (*Thermostat, error) {
+	t, _, err := c.GetThermostatRawContext(ctx, thermostatID)
+	return t, err
+}
+
+// GetThermostatRawContext is like GetThermostatContext, but also returns the
+// raw response body the thermostat was parsed from. The body can later be
+// parsed again with ParseThermostatResponse.
+func (c *Client) GetThermostatRawContext(ctx context.Context, thermostatID string) (*Thermostat, []byte, error) {
 	// TODO: Consider factoring the generation of Selection out into
 	// something else to make it more convenient to toggle the IncludeX
 	// flags?
@@ -73,52 +100,147 @@ func (c *Client) GetThermostat(thermostatID string) (*Thermostat, error) {
 		SelectionType:  "thermostats",
 		SelectionMatch: thermostatID,
 
-		IncludeAlerts:          false,
+		IncludeAlerts:          true,
 		IncludeEvents:          true,
 		IncludeProgram:         true,
 		IncludeRuntime:         true,
 		IncludeExtendedRuntime: true,
-		IncludeSettings:        false,
+		IncludeSettings:        true,
 		IncludeSensors:         true,
 		IncludeWeather:         true,
+		IncludeElectricity:     true,
+		IncludeUtility:         true,
 	}
-	thermostats, err := c.GetThermostats(s)
+	body, err := c.getThermostatsBody(ctx, GetThermostatsRequest{Selection: s})
 	if err != nil {
-		return nil, err
-	} else if len(thermostats) != 1 {
-		return nil, fmt.Errorf("got %d thermostats, wanted 1", len(thermostats))
+		return nil, nil, err
+	}
+	t, err := ParseThermostatResponse(body)
+	if err != nil {
+		return nil, nil, c.checkError(err)
 	}
-	return &thermostats[0], nil
+	return t, body, nil
 }
 
 func (c *Client) GetThermostats(selection Selection) ([]Thermostat, error) {
-	req := GetThermostatsRequest{
+	return c.GetThermostatsContext(context.Background(), selection)
+}
+
+// GetThermostatsContext is like GetThermostats, but the request is bound to
+// ctx.
+//
+// All pages of results are fetched. Selections of more thermostats than
+// Ecobee allows in one request are split across several requests.
+func (c *Client) GetThermostatsContext(ctx context.Context, selection Selection) ([]Thermostat, error) {
+	var thermostats []Thermostat
+	for _, s := range splitSelection(selection) {
+		for page := 1; ; page++ {
+			ts, p, err := c.GetThermostatsPage(ctx, s, page)
+			if err != nil {
+				return nil, err
+			}
+			thermostats = append(thermostats, ts...)
+			if page >= p.TotalPages {
+				break
+			}
+		}
+	}
+	return thermostats, nil
+}
+
+// GetThermostatsPage fetches a single page (numbered from 1) of the
+// thermostats matching the selection, returning them along with the page
+// information from the response.
+func (c *Client) GetThermostatsPage(ctx context.Context, selection Selection, page int) ([]Thermostat, Page, error) {
+	body, err := c.getThermostatsBody(ctx, GetThermostatsRequest{
 		Selection: selection,
+		Page:      &Page{Page: page},
+	})
+	if err != nil {
+		return nil, Page{}, err
+	}
+	r, err := ParseThermostatsResponse(body)
+	if err != nil {
+		return nil, Page{}, c.checkError(err)
+	}
+	return r.ThermostatList, r.Page, nil
+}
+
+// MaxThermostatsPerRequest is the largest number of thermostats the API
+// allows to be selected by identifier in a single request.
+const MaxThermostatsPerRequest = 25
+
+// splitSelection splits a selection of thermostats by identifier into
+// selections of no more than MaxThermostatsPerRequest thermostats each.
+// Other selections are returned unchanged.
+func splitSelection(selection Selection) []Selection {
+	if selection.SelectionType != "thermostats" {
+		return []Selection{selection}
 	}
+	ids := strings.Split(selection.SelectionMatch, ",")
+	if len(ids) <= MaxThermostatsPerRequest {
+		return []Selection{selection}
+	}
+	var selections []Selection
+	for len(ids) > 0 {
+		n := min(len(ids), MaxThermostatsPerRequest)
+		s := selection
+		s.SelectionMatch = strings.Join(ids[:n], ",")
+		selections = append(selections, s)
+		ids = ids[n:]
+	}
+	return selections
+}
+
+func (c *Client) getThermostatsBody(ctx context.Context, req GetThermostatsRequest) ([]byte, error) {
 	j, err := json.Marshal(&req)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling json: %v", err)
 	}
 
-	body, err := c.get(thermostatAPIURL, j)
+	body, err := c.get(ctx, thermostatAPIPath, j)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching thermostats: %v", err)
+		return nil, fmt.Errorf("error fetching thermostats: %w", err)
 	}
+	return body, nil
+}
 
+// ParseThermostatsResponse parses the body of a successful response to a
+// GetThermostats request. A non-zero status in the response is returned as
+// an *APIError.
+func ParseThermostatsResponse(body []byte) (*GetThermostatsResponse, error) {
 	var r GetThermostatsResponse
-	if err = json.Unmarshal(body, &r); err != nil {
+	if err := json.Unmarshal(body, &r); err != nil {
 		return nil, fmt.Errorf("error unmarshalling json: %v", err)
 	}
 
 	glog.V(1).Infof("GetThermostats response: %#v", r)
 
-	if r.Status.Code != 0 {
-		return nil, fmt.Errorf("api error %d: %v", r.Status.Code, r.Status.Message)
+	if r.Status.Code != StatusSuccess {
+		return nil, &APIError{HTTPStatus: 200, Code: r.Status.Code, Message: r.Status.Message}
 	}
-	return r.ThermostatList, nil
+	return &r, nil
+}
+
+// ParseThermostatResponse parses the body of a response to a GetThermostats
+// request for a single thermostat, as returned by GetThermostatRawContext.
+func ParseThermostatResponse(body []byte) (*Thermostat, error) {
+	r, err := ParseThermostatsResponse(body)
+	if err != nil {
+		return nil, err
+	} else if len(r.ThermostatList) != 1 {
+		return nil, fmt.Errorf("got %d thermostats, wanted 1", len(r.ThermostatList))
+	}
+	return &r.ThermostatList[0], nil
 }
 
 func (c *Client) GetThermostatSummary(selection Selection) (map[string]ThermostatSummary, error) {
+	return c.GetThermostatSummaryContext(context.Background(), selection)
+}
+
+// GetThermostatSummaryContext is like GetThermostatSummary, but the request
+// is bound to ctx.
+func (c *Client) GetThermostatSummaryContext(ctx context.Context, selection Selection) (map[string]ThermostatSummary, error) {
 	req := GetThermostatSummaryRequest{
 		Selection: selection,
 	}
@@ -127,9 +249,9 @@ func (c *Client) GetThermostatSummary(selection Selection) (map[string]Thermosta
 		return nil, fmt.Errorf("error marshaling json: %v", err)
 	}
 
-	body, err := c.get(thermostatSummaryURL, j)
+	body, err := c.get(ctx, thermostatSummaryPath, j)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching thermostat summary: %v", err)
+		return nil, fmt.Errorf("error fetching thermostat summary: %w", err)
 	}
 
 	var r GetThermostatSummaryResponse
@@ -139,6 +261,10 @@ func (c *Client) GetThermostatSummary(selection Selection) (map[string]Thermosta
 
 	glog.V(1).Infof("GetThermostatSummary response: %#v", r)
 
+	if r.Status.Code != StatusSuccess {
+		return nil, c.apiError(&APIError{HTTPStatus: 200, Code: r.Status.Code, Message: r.Status.Message})
+	}
+
 	var tsm = make(ThermostatSummaryMap, r.ThermostatCount)
 
 	for i := 0; i < r.ThermostatCount; i++ {
@@ -173,20 +299,24 @@ func (c *Client) GetThermostatSummary(selection Selection) (map[string]Thermosta
 	return tsm, nil
 }
 
-func (c *Client) get(endpoint string, rawRequest []byte) ([]byte, error) {
+func (c *Client) get(ctx context.Context, endpoint string, rawRequest []byte) ([]byte, error) {
 
 	glog.V(2).Infof("get(%s?json=%s)", endpoint, rawRequest)
 	request := url.QueryEscape(string(rawRequest))
-	resp, err := c.Get(fmt.Sprintf("%s?json=%s", endpoint, request))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%s?json=%s", c.baseURL, endpoint, request), nil)
 	if err != nil {
-		return nil, fmt.Errorf("error on get request: %v", err)
+		return nil, fmt.Errorf("error building get request: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("invalid server response: %v", resp.Status)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error on get request: %w", err)
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return nil, c.apiError(newAPIError(resp.StatusCode, body))
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error reading body: %v", err)
 	}
@@ -196,6 +326,25 @@ func (c *Client) get(endpoint string, rawRequest []byte) ([]byte, error) {
 	return body, nil
 }
 
+// checkError passes any *APIError within err through apiError, returning err.
+func (c *Client) checkError(err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		c.apiError(apiErr)
+	}
+	return err
+}
+
+// apiError reacts to an error reported by the API before returning it. When
+// Ecobee reports that the access token has expired, the token is discarded so
+// the next request refreshes it.
+func (c *Client) apiError(e *APIError) *APIError {
+	if e.Code == StatusAuthTokenExpired && c.ts != nil {
+		c.ts.expire()
+	}
+	return e
+}
+
 func buildEquipmentStatus(input string) (EquipmentStatus, error) {
 	var es EquipmentStatus
 
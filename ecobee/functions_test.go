@@ -0,0 +1,58 @@
+package ecobee
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitSelection(t *testing.T) {
+	ids := func(from, to int) string {
+		var s []string
+		for i := from; i < to; i++ {
+			s = append(s, fmt.Sprintf("3110000%05d", i))
+		}
+		return strings.Join(s, ",")
+	}
+	thermostats := func(match string) Selection {
+		return Selection{SelectionType: "thermostats", SelectionMatch: match, IncludeRuntime: true}
+	}
+
+	for _, tc := range []struct {
+		name string
+		in   Selection
+		want []Selection
+	}{
+		{
+			name: "registered",
+			in:   Selection{SelectionType: "registered"},
+			want: []Selection{{SelectionType: "registered"}},
+		},
+		{
+			name: "one thermostat",
+			in:   thermostats(ids(0, 1)),
+			want: []Selection{thermostats(ids(0, 1))},
+		},
+		{
+			name: "maximum per request",
+			in:   thermostats(ids(0, MaxThermostatsPerRequest)),
+			want: []Selection{thermostats(ids(0, MaxThermostatsPerRequest))},
+		},
+		{
+			name: "over the maximum",
+			in:   thermostats(ids(0, 2*MaxThermostatsPerRequest+1)),
+			want: []Selection{
+				thermostats(ids(0, MaxThermostatsPerRequest)),
+				thermostats(ids(MaxThermostatsPerRequest, 2*MaxThermostatsPerRequest)),
+				thermostats(ids(2*MaxThermostatsPerRequest, 2*MaxThermostatsPerRequest+1)),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := splitSelection(tc.in); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("splitSelection = %+v; want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -98,6 +98,87 @@ func (c *Client) SendMessage(thermostat, message string) error {
 	return c.UpdateThermostat(*r)
 }
 
+// Acknowledge acknowledges the thermostat alert with the given
+// acknowledgeRef. ackType is one of the AckType constants.
+func (c *Client) Acknowledge(thermostat, ackRef, ackType string, remindMeLater bool) error {
+	switch ackType {
+	case AckTypeAccept, AckTypeDecline, AckTypeDefer, AckTypeUnacknowledged:
+	default:
+		return fmt.Errorf("invalid acknowledgement type %q", ackType)
+	}
+	if ackRef == "" {
+		return fmt.Errorf("ackRef must not be empty")
+	}
+
+	r := &UpdateThermostatRequest{
+		Selection: Selection{
+			SelectionType:  "thermostats",
+			SelectionMatch: thermostat,
+		},
+		Functions: []Function{
+			Function{
+				Type: "acknowledge",
+				Params: AcknowledgeParams{
+					ThermostatIdentifier: thermostat,
+					AckRef:               ackRef,
+					AckType:              ackType,
+					RemindMeLater:        remindMeLater,
+				},
+			},
+		},
+	}
+
+	return c.UpdateThermostat(*r)
+}
+
+// UpdateProgram replaces the thermostat's program (its schedule and
+// climates) with p.
+func (c *Client) UpdateProgram(thermostat string, p Program) error {
+	p.CurrentClimateRef = "" // read-only
+	r := &UpdateThermostatRequest{
+		Selection: Selection{
+			SelectionType:  "thermostats",
+			SelectionMatch: thermostat,
+		},
+		Thermostat: &ThermostatUpdate{
+			Program: &p,
+		},
+	}
+
+	return c.UpdateThermostat(*r)
+}
+
+// UpdateSensor renames one of the thermostat's sensors. deviceID is the
+// sensor's ID (e.g. "rs:100") and sensorID is the ID of one of its
+// capabilities (e.g. "1").
+func (c *Client) UpdateSensor(thermostat, deviceID, sensorID, name string) error {
+	if deviceID == "" || sensorID == "" {
+		return fmt.Errorf("deviceID and sensorID must not be empty")
+	}
+	if name == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+
+	r := &UpdateThermostatRequest{
+		Selection: Selection{
+			SelectionType:  "thermostats",
+			SelectionMatch: thermostat,
+		},
+		Functions: []Function{
+			Function{
+				Type: "updateSensor",
+				Params: UpdateSensorParams{
+					Name:     name,
+					DeviceID: deviceID,
+					SensorID: sensorID,
+				},
+			},
+		},
+	}
+
+	return c.UpdateThermostat(*r)
+}
+
 // The Ecobee API represents temperatures as integers.
 func makeTemp(h, c float64) (int, int) {
 	return int(h * 10), int(c * 10)
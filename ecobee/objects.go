@@ -62,23 +62,26 @@ type SetHoldParams struct {
 	HoldHours      int    `json:"holdHours,omitempty"`
 }
 
+// Alert is a thermostat alert or reminder. It is also embedded in
+// SendMessageParams, so fields other than Text which don't apply to sent
+// messages are omitted when empty.
 type Alert struct {
-	//	AcknowledgeRef       string `json:"acknowledgeRef"`
-	//	Date                 string `json:"date"`
-	//	Time                 string `json:"time"`
-	//	Severity             string `json:"severity"`
-	Text string `json:"text"`
-	//	AlertNumber          int    `json:"alertNumber"`
-	AlertType       string `json:"alertType"`
-	IsOperatorAlert bool   `json:"isOperatorAlert"`
-	// Reminder             string `json:"reminder"`
-	// ShowIdt              bool   `json:"showIdt"`
-	// ShowWeb              bool   `json:"showWeb"`
-	// SendEmail            bool   `json:"sendEmail"`
-	// Acknowledgement      string `json:"acknowledgement"`
-	// RemindMeLater        bool   `json:"remindMeLater"`
-	// ThermostatIdentifier string `json:"thermostatIdentifier"`
-	// NotificationType     string `json:"notificationType"`
+	AcknowledgeRef       string `json:"acknowledgeRef,omitempty"`
+	Date                 string `json:"date,omitempty"`
+	Time                 string `json:"time,omitempty"`
+	Severity             string `json:"severity,omitempty"`
+	Text                 string `json:"text"`
+	AlertNumber          int    `json:"alertNumber,omitempty"`
+	AlertType            string `json:"alertType"`
+	IsOperatorAlert      bool   `json:"isOperatorAlert"`
+	Reminder             string `json:"reminder,omitempty"`
+	ShowIdt              bool   `json:"showIdt,omitempty"`
+	ShowWeb              bool   `json:"showWeb,omitempty"`
+	SendEmail            bool   `json:"sendEmail,omitempty"`
+	Acknowledgement      string `json:"acknowledgement,omitempty"`
+	RemindMeLater        bool   `json:"remindMeLater,omitempty"`
+	ThermostatIdentifier string `json:"thermostatIdentifier,omitempty"`
+	NotificationType     string `json:"notificationType,omitempty"`
 }
 
 type SendMessageParams struct {
@@ -86,6 +89,29 @@ type SendMessageParams struct {
 	Text string `json:"text"`
 }
 
+// Acknowledgement types for AcknowledgeParams.AckType.
+const (
+	AckTypeAccept         = "accept"
+	AckTypeDecline        = "decline"
+	AckTypeDefer          = "defer"
+	AckTypeUnacknowledged = "unacknowledged"
+)
+
+type AcknowledgeParams struct {
+	ThermostatIdentifier string `json:"thermostatIdentifier"`
+	AckRef               string `json:"ackRef"`
+	AckType              string `json:"ackType"`
+	RemindMeLater        bool   `json:"remindMeLater,omitempty"`
+}
+
+// UpdateSensorParams renames a sensor. DeviceID is the sensor's ID (e.g.
+// "rs:100"), and SensorID is the ID of one of its capabilities (e.g. "1").
+type UpdateSensorParams struct {
+	Name     string `json:"name"`
+	DeviceID string `json:"deviceId"`
+	SensorID string `json:"sensorId"`
+}
+
 type Selection struct {
 	SelectionType               string `json:"selectionType"`
 	SelectionMatch              string `json:"selectionMatch"`
@@ -119,8 +145,15 @@ type Function struct {
 }
 
 type UpdateThermostatRequest struct {
-	Selection Selection  `json:"selection"`
-	Functions []Function `json:"functions"`
+	Selection  Selection         `json:"selection"`
+	Thermostat *ThermostatUpdate `json:"thermostat,omitempty"`
+	Functions  []Function        `json:"functions,omitempty"`
+}
+
+// ThermostatUpdate holds the parts of a thermostat object to write with an
+// UpdateThermostatRequest. Nil fields are left unchanged.
+type ThermostatUpdate struct {
+	Program *Program `json:"program,omitempty"`
 }
 
 type UpdateThermostatResponse struct {
@@ -137,26 +170,230 @@ type Status struct {
 }
 
 type Thermostat struct {
-	Identifier     string `json:"identifier"`
-	Name           string `json:"name"`
-	ThermostatRev  string `json:"thermostatRev"`
-	IsRegistered   bool   `json:"isRegistered"`
-	ModelNumber    string `json:"modelNumber"`
-	Brand          string `json:"brand"`
-	Features       string `json:"features"`
-	LastModified   string `json:"lastModified"`
-	ThermostatTime string `json:"thermostatTime"`
-	UtcTime        string `json:"utcTime"`
-	//Alerts         []Alert  `json:"alerts"`
-	//Settings       Settings `json:"settings"`
+	Identifier      string          `json:"identifier"`
+	Name            string          `json:"name"`
+	ThermostatRev   string          `json:"thermostatRev"`
+	IsRegistered    bool            `json:"isRegistered"`
+	ModelNumber     string          `json:"modelNumber"`
+	Brand           string          `json:"brand"`
+	Features        string          `json:"features"`
+	LastModified    string          `json:"lastModified"`
+	ThermostatTime  string          `json:"thermostatTime"`
+	UtcTime         string          `json:"utcTime"`
+	Alerts          []Alert         `json:"alerts"`
+	Settings        Settings        `json:"settings"`
 	Runtime         Runtime         `json:"runtime"`
 	ExtendedRuntime ExtendedRuntime `json:"extendedRuntime"`
 	/// ...
-	Events  []Event `json:"events"`
-	Program Program `json:"program"`
+	Events   []Event  `json:"events"`
+	Program  Program  `json:"program"`
+	Devices  []Device `json:"devices"`
+	Location Location `json:"location"`
+	Version  Version  `json:"version"`
 	/// ...
 	RemoteSensors []RemoteSensor `json:"remoteSensors"`
 	Weather       Weather        `json:"weather"`
+	Electricity   Electricity    `json:"electricity"`
+	Utility       Utility        `json:"utility"`
+}
+
+// Version describes the thermostat's software.
+type Version struct {
+	ThermostatFirmwareVersion string `json:"thermostatFirmwareVersion"`
+}
+
+// Location describes where the thermostat is installed.
+type Location struct {
+	TimeZoneOffsetMinutes int    `json:"timeZoneOffsetMinutes"`
+	TimeZone              string `json:"timeZone"`
+	IsDaylightSaving      bool   `json:"isDaylightSaving"`
+	StreetAddress         string `json:"streetAddress"`
+	City                  string `json:"city"`
+	ProvinceState         string `json:"provinceState"`
+	Country               string `json:"country"`
+	PostalCode            string `json:"postalCode"`
+	PhoneNumber           string `json:"phoneNumber"`
+	MapCoordinates        string `json:"mapCoordinates"` // "latitude, longitude"
+}
+
+// Device is a piece of hardware attached to the thermostat, with its wired
+// sensors and outputs.
+type Device struct {
+	DeviceID int            `json:"deviceId"`
+	Name     string         `json:"name"`
+	Sensors  []DeviceSensor `json:"sensors"`
+	Outputs  []Output       `json:"outputs"`
+}
+
+// DeviceSensor is a sensor wired to a Device; it's unrelated to
+// RemoteSensor.
+type DeviceSensor struct {
+	Name           string `json:"name"`
+	Manufacturer   string `json:"manufacturer"`
+	Model          string `json:"model"`
+	Zone           int    `json:"zone"`
+	SensorID       int    `json:"sensorId"`
+	Type           string `json:"type"`
+	Usage          string `json:"usage"`
+	NumberOfBits   int    `json:"numberOfBits"`
+	Bconstant      int    `json:"bconstant"`
+	ThermistorSize int    `json:"thermistorSize"`
+	TempCorrection int    `json:"tempCorrection"`
+	Gain           int    `json:"gain"`
+	MaxVoltage     int    `json:"maxVoltage"`
+	Multiplier     int    `json:"multiplier"`
+}
+
+// Output is a relay or other output of a Device.
+type Output struct {
+	Name             string `json:"name"`
+	Zone             int    `json:"zone"`
+	OutputID         int    `json:"outputId"`
+	Type             string `json:"type"`
+	SendUpdate       bool   `json:"sendUpdate"`
+	ActiveClosed     bool   `json:"activeClosed"`
+	ActivationTime   int    `json:"activationTime"`
+	DeactivationTime int    `json:"deactivationTime"`
+}
+
+type Electricity struct {
+	Devices []ElectricityDevice `json:"devices"`
+}
+
+type ElectricityDevice struct {
+	Tiers       []ElectricityTier `json:"tiers"`
+	LastUpdate  string            `json:"lastUpdate"`
+	Cost        []string          `json:"cost"`
+	Consumption []string          `json:"consumption"`
+}
+
+type ElectricityTier struct {
+	Name        string `json:"name"`
+	Consumption string `json:"consumption"`
+	Cost        string `json:"cost"`
+}
+
+type Utility struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+	Email string `json:"email"`
+	Web   string `json:"web"`
+}
+
+type Settings struct {
+	HvacMode                            string `json:"hvacMode"`
+	LastServiceDate                     string `json:"lastServiceDate"`
+	ServiceRemindMe                     bool   `json:"serviceRemindMe"`
+	MonthsBetweenService                int    `json:"monthsBetweenService"`
+	RemindMeDate                        string `json:"remindMeDate"`
+	Vent                                string `json:"vent"`
+	VentilatorMinOnTime                 int    `json:"ventilatorMinOnTime"`
+	ServiceRemindTechnician             bool   `json:"serviceRemindTechnician"`
+	EiLocation                          string `json:"eiLocation"`
+	ColdTempAlert                       int    `json:"coldTempAlert"`
+	ColdTempAlertEnabled                bool   `json:"coldTempAlertEnabled"`
+	HotTempAlert                        int    `json:"hotTempAlert"`
+	HotTempAlertEnabled                 bool   `json:"hotTempAlertEnabled"`
+	CoolStages                          int    `json:"coolStages"`
+	HeatStages                          int    `json:"heatStages"`
+	MaxSetBack                          int    `json:"maxSetBack"`
+	MaxSetForward                       int    `json:"maxSetForward"`
+	QuickSaveSetBack                    int    `json:"quickSaveSetBack"`
+	QuickSaveSetForward                 int    `json:"quickSaveSetForward"`
+	HasHeatPump                         bool   `json:"hasHeatPump"`
+	HasForcedAir                        bool   `json:"hasForcedAir"`
+	HasBoiler                           bool   `json:"hasBoiler"`
+	HasHumidifier                       bool   `json:"hasHumidifier"`
+	HasErv                              bool   `json:"hasErv"`
+	HasHrv                              bool   `json:"hasHrv"`
+	CondensationAvoid                   bool   `json:"condensationAvoid"`
+	UseCelsius                          bool   `json:"useCelsius"`
+	UseTimeFormat12                     bool   `json:"useTimeFormat12"`
+	Locale                              string `json:"locale"`
+	Humidity                            string `json:"humidity"`
+	HumidifierMode                      string `json:"humidifierMode"`
+	BacklightOnIntensity                int    `json:"backlightOnIntensity"`
+	BacklightSleepIntensity             int    `json:"backlightSleepIntensity"`
+	BacklightOffTime                    int    `json:"backlightOffTime"`
+	SoundTickVolume                     int    `json:"soundTickVolume"`
+	SoundAlertVolume                    int    `json:"soundAlertVolume"`
+	CompressorProtectionMinTime         int    `json:"compressorProtectionMinTime"`
+	CompressorProtectionMinTemp         int    `json:"compressorProtectionMinTemp"`
+	Stage1HeatingDifferentialTemp       int    `json:"stage1HeatingDifferentialTemp"`
+	Stage1CoolingDifferentialTemp       int    `json:"stage1CoolingDifferentialTemp"`
+	Stage1HeatingDissipationTime        int    `json:"stage1HeatingDissipationTime"`
+	Stage1CoolingDissipationTime        int    `json:"stage1CoolingDissipationTime"`
+	HeatPumpReversalOnCool              bool   `json:"heatPumpReversalOnCool"`
+	FanControlRequired                  bool   `json:"fanControlRequired"`
+	FanMinOnTime                        int    `json:"fanMinOnTime"`
+	HeatCoolMinDelta                    int    `json:"heatCoolMinDelta"`
+	TempCorrection                      int    `json:"tempCorrection"`
+	HoldAction                          string `json:"holdAction"`
+	HeatPumpGroundWater                 bool   `json:"heatPumpGroundWater"`
+	HasElectric                         bool   `json:"hasElectric"`
+	HasDehumidifier                     bool   `json:"hasDehumidifier"`
+	DehumidifierMode                    string `json:"dehumidifierMode"`
+	DehumidifierLevel                   int    `json:"dehumidifierLevel"`
+	DehumidifyWithAC                    bool   `json:"dehumidifyWithAC"`
+	DehumidifyOvercoolOffset            int    `json:"dehumidifyOvercoolOffset"`
+	AutoHeatCoolFeatureEnabled          bool   `json:"autoHeatCoolFeatureEnabled"`
+	WifiOfflineAlert                    bool   `json:"wifiOfflineAlert"`
+	HeatMinTemp                         int    `json:"heatMinTemp"`
+	HeatMaxTemp                         int    `json:"heatMaxTemp"`
+	CoolMinTemp                         int    `json:"coolMinTemp"`
+	CoolMaxTemp                         int    `json:"coolMaxTemp"`
+	HeatRangeHigh                       int    `json:"heatRangeHigh"`
+	HeatRangeLow                        int    `json:"heatRangeLow"`
+	CoolRangeHigh                       int    `json:"coolRangeHigh"`
+	CoolRangeLow                        int    `json:"coolRangeLow"`
+	UserAccessCode                      string `json:"userAccessCode"`
+	UserAccessSetting                   int    `json:"userAccessSetting"`
+	AuxRuntimeAlert                     int    `json:"auxRuntimeAlert"`
+	AuxOutdoorTempAlert                 int    `json:"auxOutdoorTempAlert"`
+	AuxMaxOutdoorTemp                   int    `json:"auxMaxOutdoorTemp"`
+	AuxRuntimeAlertNotify               bool   `json:"auxRuntimeAlertNotify"`
+	AuxOutdoorTempAlertNotify           bool   `json:"auxOutdoorTempAlertNotify"`
+	AuxRuntimeAlertNotifyTechnician     bool   `json:"auxRuntimeAlertNotifyTechnician"`
+	AuxOutdoorTempAlertNotifyTechnician bool   `json:"auxOutdoorTempAlertNotifyTechnician"`
+	DisablePreHeating                   bool   `json:"disablePreHeating"`
+	DisablePreCooling                   bool   `json:"disablePreCooling"`
+	InstallerCodeRequired               bool   `json:"installerCodeRequired"`
+	DrAccept                            string `json:"drAccept"`
+	IsRentalProperty                    bool   `json:"isRentalProperty"`
+	UseZoneController                   bool   `json:"useZoneController"`
+	RandomStartDelayCool                int    `json:"randomStartDelayCool"`
+	RandomStartDelayHeat                int    `json:"randomStartDelayHeat"`
+	HumidityHighAlert                   int    `json:"humidityHighAlert"`
+	HumidityLowAlert                    int    `json:"humidityLowAlert"`
+	DisableHeatPumpAlerts               bool   `json:"disableHeatPumpAlerts"`
+	DisableAlertsOnIdt                  bool   `json:"disableAlertsOnIdt"`
+	HumidityAlertNotify                 bool   `json:"humidityAlertNotify"`
+	HumidityAlertNotifyTechnician       bool   `json:"humidityAlertNotifyTechnician"`
+	TempAlertNotify                     bool   `json:"tempAlertNotify"`
+	TempAlertNotifyTechnician           bool   `json:"tempAlertNotifyTechnician"`
+	MonthlyElectricityBillLimit         int    `json:"monthlyElectricityBillLimit"`
+	EnableElectricityBillAlert          bool   `json:"enableElectricityBillAlert"`
+	EnableProjectedElectricityBillAlert bool   `json:"enableProjectedElectricityBillAlert"`
+	ElectricityBillingDayOfMonth        int    `json:"electricityBillingDayOfMonth"`
+	ElectricityBillCycleMonths          int    `json:"electricityBillCycleMonths"`
+	ElectricityBillStartMonth           int    `json:"electricityBillStartMonth"`
+	VentilatorMinOnTimeHome             int    `json:"ventilatorMinOnTimeHome"`
+	VentilatorMinOnTimeAway             int    `json:"ventilatorMinOnTimeAway"`
+	BacklightOffDuringSleep             bool   `json:"backlightOffDuringSleep"`
+	AutoAway                            bool   `json:"autoAway"`
+	SmartCirculation                    bool   `json:"smartCirculation"`
+	FollowMeComfort                     bool   `json:"followMeComfort"`
+	VentilatorType                      string `json:"ventilatorType"`
+	IsVentilatorTimerOn                 bool   `json:"isVentilatorTimerOn"`
+	VentilatorOffDateTime               string `json:"ventilatorOffDateTime"`
+	HasUVFilter                         bool   `json:"hasUVFilter"`
+	CoolingLockout                      bool   `json:"coolingLockout"`
+	VentilatorFreeCooling               bool   `json:"ventilatorFreeCooling"`
+	DehumidifyWhenHeating               bool   `json:"dehumidifyWhenHeating"`
+	VentilatorDehumidify                bool   `json:"ventilatorDehumidify"`
+	GroupRef                            string `json:"groupRef"`
+	GroupName                           string `json:"groupName"`
+	GroupSetting                        int    `json:"groupSetting"`
 }
 
 type Runtime struct {
@@ -214,29 +451,32 @@ type ExtendedRuntime struct {
 
 type GetThermostatsRequest struct {
 	Selection Selection `json:"selection"`
-	Page      Page      `json:"page,omitempty"`
+	Page      *Page     `json:"page,omitempty"`
 }
 
 type GetThermostatsResponse struct {
-	Page           Page
+	Page           Page         `json:"page"`
 	ThermostatList []Thermostat `json:"thermostatList"`
 	Status         Status       `json:"status"`
 }
 
 type Page struct {
 	Page       int `json:"page,omitempty"`
-	TotalPages int `json:"totalPages"`
-	PageSize   int `json:"pageSize"`
-	Total      int `json:"total"`
+	TotalPages int `json:"totalPages,omitempty"`
+	PageSize   int `json:"pageSize,omitempty"`
+	Total      int `json:"total,omitempty"`
 }
 
+// RemoteSensor is a sensor, or a climate's reference to a sensor. Climates
+// identify sensors by ID and name only, so the other fields are omitted when
+// empty, for program updates.
 type RemoteSensor struct {
 	ID         string                   `json:"id"`
 	Name       string                   `json:"name"`
-	Type       string                   `json:"type"`
-	Code       string                   `json:"code"`
-	InUse      bool                     `json:"inUse"`
-	Capability []RemoteSensorCapability `json:"capability"`
+	Type       string                   `json:"type,omitempty"`
+	Code       string                   `json:"code,omitempty"`
+	InUse      bool                     `json:"inUse,omitempty"`
+	Capability []RemoteSensorCapability `json:"capability,omitempty"`
 }
 
 type RemoteSensorCapability struct {
@@ -265,7 +505,7 @@ type Climate struct {
 type Program struct {
 	Schedule          [][]string `json:"schedule"`
 	Climates          []Climate  `json:"climates"`
-	CurrentClimateRef string     `json:"currentClimateRef"`
+	CurrentClimateRef string     `json:"currentClimateRef,omitempty"` // read-only
 }
 
 type GetThermostatSummaryRequest struct {
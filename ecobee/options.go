@@ -0,0 +1,104 @@
+package ecobee
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultBaseURL = "https://api.ecobee.com"
+
+// ClientOption configures optional behavior of a Client created by NewClient,
+// and of the Authorize and SaveToken functions.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	nonInteractive bool
+	cacheKey       string
+	baseURL        string
+	timeout        time.Duration
+	userAgent      string
+	transport      http.RoundTripper
+}
+
+func buildClientOptions(opts []ClientOption) clientOptions {
+	o := clientOptions{baseURL: defaultBaseURL}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// httpClient returns the HTTP client used for authorization requests, which
+// also serves as the base for authenticated API requests.
+func (o clientOptions) httpClient() *http.Client {
+	rt := o.transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if o.userAgent != "" {
+		rt = &userAgentTransport{base: rt, userAgent: o.userAgent}
+	}
+	return &http.Client{Transport: rt, Timeout: o.timeout}
+}
+
+// NonInteractive disables the interactive PIN flow on first use. Requests made
+// without cached credentials fail with ErrAuthorizationRequired instead of
+// waiting for input on stdin.
+func NonInteractive() ClientOption {
+	return func(o *clientOptions) {
+		o.nonInteractive = true
+	}
+}
+
+// WithCacheKey encrypts the credential cache using a key derived from the
+// given passphrase with scrypt and a random salt. An existing plaintext cache
+// is still read, and is encrypted the next time the token is saved.
+func WithCacheKey(passphrase string) ClientOption {
+	return func(o *clientOptions) {
+		o.cacheKey = passphrase
+	}
+}
+
+// WithBaseURL directs all requests, including authorization requests, to
+// the given base URL (eg. "http://127.0.0.1:8080") instead of
+// https://api.ecobee.com.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(o *clientOptions) {
+		o.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithTimeout sets a timeout for each HTTP request. By default, requests have
+// no timeout beyond any deadline on the context passed to the request.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with each request.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithTransport sets the http.RoundTripper used to make requests.
+// http.DefaultTransport is used by default.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
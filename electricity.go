@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// processElectricity writes the thermostat's electricity bill and tiered
+// usage, for thermostats whose utility provides that data.
+func (c *connector) processElectricity(t *ecobee.Thermostat, at time.Time) error {
+	current := t.ExtendedRuntime.CurrentElectricityBill
+	projected := t.ExtendedRuntime.ProjectedElectricityBill
+	if current == 0 && projected == 0 && len(t.Electricity.Devices) == 0 {
+		// no electricity data for this thermostat
+		return nil
+	}
+
+	tags := map[string]string{thermostatNameTag: t.Name}
+	if t.Utility.Name != "" {
+		tags["utility"] = t.Utility.Name
+	}
+
+	fmt.Printf("Electricity at %s:\n", at)
+	fmt.Printf("\tcurrent bill: %d\n\tprojected bill: %d\n", current, projected)
+	if err := c.out.write(
+		"ecobee_electricity",
+		tags,
+		map[string]any{
+			"current_bill":   current,
+			"projected_bill": projected,
+		},
+		at,
+		"electricity",
+	); err != nil {
+		return err
+	}
+
+	for i, device := range t.Electricity.Devices {
+		deviceTime := at
+		if device.LastUpdate != "" {
+			if lu, err := time.Parse("2006-01-02 15:04:05", device.LastUpdate); err == nil {
+				deviceTime = lu
+			}
+		}
+		for _, tier := range device.Tiers {
+			fields := make(map[string]any)
+			if v, err := strconv.ParseFloat(tier.Consumption, 64); err == nil {
+				fields["consumption"] = v
+			} else if tier.Consumption != "" {
+				log.Printf("error reading consumption '%s' for electricity tier %s: %s", tier.Consumption, tier.Name, err)
+			}
+			if v, err := strconv.ParseFloat(tier.Cost, 64); err == nil {
+				fields["cost"] = v
+			} else if tier.Cost != "" {
+				log.Printf("error reading cost '%s' for electricity tier %s: %s", tier.Cost, tier.Name, err)
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			fmt.Printf("\ttier '%s': consumption %s, cost %s\n", tier.Name, tier.Consumption, tier.Cost)
+
+			tierTags := map[string]string{
+				"device": strconv.Itoa(i),
+				"tier":   tier.Name,
+			}
+			for k, v := range tags {
+				tierTags[k] = v
+			}
+			if err := c.out.write(
+				"ecobee_electricity",
+				tierTags,
+				fields,
+				deviceTime,
+				fmt.Sprintf("electricity/%d/%s", i, tier.Name),
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	wx "github.com/cdzombak/libwx"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const eventStateFileName = "ecobee-event-state.json"
+
+// eventState records the event which was running at the last poll, so that
+// start and end markers are written once per event, even across restarts.
+type eventState struct {
+	Key   string        `json:"key"` // empty if no event was running
+	Event *ecobee.Event `json:"event,omitempty"`
+}
+
+// eventKey identifies an event.
+func eventKey(e ecobee.Event) string {
+	return fmt.Sprintf("%s|%s|%s %s", e.Type, e.Name, e.StartDate, e.StartTime)
+}
+
+// runningEvent returns the event currently in effect, if any. Events are
+// listed in order of priority, so this is the first running one.
+func runningEvent(t *ecobee.Thermostat) *ecobee.Event {
+	for i := range t.Events {
+		if t.Events[i].Running {
+			return &t.Events[i]
+		}
+	}
+	return nil
+}
+
+// eventTime converts an event's local date and time to UTC, falling back to
+// the given time if they're unset.
+func eventTime(t *ecobee.Thermostat, date, clock string, fallback time.Time) time.Time {
+	et, err := time.Parse("2006-01-02 15:04:05", date+" "+clock)
+	if err != nil {
+		return fallback
+	}
+	return et.Add(-thermostatUTCOffset(t))
+}
+
+// processEvents writes the running event (or its absence), and writes a
+// marker when an event starts or ends.
+func (c *connector) processEvents(t *ecobee.Thermostat, now time.Time) error {
+	e := runningEvent(t)
+	key := ""
+	if e != nil {
+		key = eventKey(*e)
+	}
+
+	tags := map[string]string{thermostatNameTag: t.Name, "event_type": "none"}
+	fields := map[string]any{"active": false}
+	if e != nil {
+		heatHold := wx.TempF(float64(e.HeatHoldTemp) / 10.0)
+		coolHold := wx.TempF(float64(e.CoolHoldTemp) / 10.0)
+		tags["event_type"] = e.Type
+		tags["event_name"] = e.Name
+		fields = map[string]any{
+			"active":           true,
+			"heat_hold_temp_f": heatHold.Unwrap(),
+			"heat_hold_temp_c": heatHold.C().Unwrap(),
+			"cool_hold_temp_f": coolHold.Unwrap(),
+			"cool_hold_temp_c": coolHold.C().Unwrap(),
+			"fan":              e.Fan,
+			"hold_climate_ref": e.HoldClimateRef,
+			"is_occupied":      e.IsOccupied,
+			"is_temp_absolute": e.IsTemperatureAbsolute,
+			"start_time":       eventTime(t, e.StartDate, e.StartTime, now).Format(time.RFC3339),
+			"fan_min_on_time":  e.FanMinOnTime,
+			"is_cool_off":      e.IsCoolOff,
+			"is_heat_off":      e.IsHeatOff,
+		}
+		if e.EndDate != "" {
+			fields["end_time"] = eventTime(t, e.EndDate, e.EndTime, now).Format(time.RFC3339)
+		}
+
+		fmt.Printf("Running event at %s:\n", now)
+		fmt.Printf("\t%s '%s': heat %.1f degF, cool %.1f degF, fan %s, until %s %s\n",
+			e.Type, e.Name, heatHold, coolHold, e.Fan, e.EndDate, e.EndTime)
+	}
+	if err := c.out.write("ecobee_event", tags, fields, now, "event"); err != nil {
+		return err
+	}
+
+	if key == c.events.Key {
+		return nil
+	}
+	if c.events.Event != nil {
+		if err := c.writeEventMarker(t, *c.events.Event, "end", now); err != nil {
+			return err
+		}
+	}
+	if e != nil {
+		if err := c.writeEventMarker(t, *e, "start", eventTime(t, e.StartDate, e.StartTime, now)); err != nil {
+			return err
+		}
+	}
+	c.events.Key = key
+	c.events.Event = e
+	if c.stateDir != "" {
+		if err := saveState(c.stateDir, eventStateFileName, c.events); err != nil {
+			log.Printf("failed to save event state: %s", err)
+		}
+	}
+	return nil
+}
+
+// writeEventMarker writes a point marking the start or end of an event,
+// suitable for use as a Grafana annotation.
+func (c *connector) writeEventMarker(t *ecobee.Thermostat, e ecobee.Event, marker string, at time.Time) error {
+	text := fmt.Sprintf("%s %s", e.Type, marker)
+	if e.Name != "" {
+		text = fmt.Sprintf("%s '%s' %s", e.Type, e.Name, marker)
+	}
+	if e.Type == "hold" && e.HoldClimateRef != "" {
+		text += fmt.Sprintf(" (%s)", e.HoldClimateRef)
+	}
+	return c.out.write(
+		"ecobee_event_marker",
+		map[string]string{
+			thermostatNameTag: t.Name,
+			"event_type":      e.Type,
+			"event_name":      e.Name,
+			"marker":          marker,
+		},
+		map[string]any{
+			"text": text,
+		},
+		at,
+		"event_marker",
+	)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	wx "github.com/cdzombak/libwx"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// processForecasts writes each entry of the weather forecast issued at
+// issued, timestamped at the time it forecasts and tagged with its horizon
+// (how far ahead of issued that time is, e.g. "12h"). Entries whose time
+// can't be parsed are skipped.
+func (c *connector) processForecasts(t *ecobee.Thermostat, issued time.Time, tags map[string]string) error {
+	for _, f := range t.Weather.Forecasts {
+		// Forecast times are in the thermostat's local time:
+		forecastTime, err := time.Parse("2006-01-02 15:04:05", f.DateTime)
+		if err != nil {
+			log.Printf("error reading forecast time '%s': %s", f.DateTime, err)
+			continue
+		}
+		forecastTime = forecastTime.Add(-thermostatUTCOffset(t))
+		horizon := fmt.Sprintf("%dh", int(math.Round(forecastTime.Sub(issued).Hours())))
+
+		temp := wx.TempF(float64(f.Temperature) / 10.0)
+		high := wx.TempF(float64(f.TempHigh) / 10.0)
+		low := wx.TempF(float64(f.TempLow) / 10.0)
+		dewpoint := wx.TempF(float64(f.Dewpoint) / 10.0)
+		pressure := wx.PressureMb(f.Pressure)
+		windSpeed := wx.SpeedMph(f.WindSpeed)
+		windGust := wx.SpeedMph(f.WindGust)
+		visibility := wx.Meter(f.Visibility).Miles()
+
+		forecastTags := map[string]string{"horizon": horizon}
+		for k, v := range tags {
+			forecastTags[k] = v
+		}
+		if err := c.out.write(
+			"ecobee_weather_forecast",
+			forecastTags,
+			map[string]any{
+				"temp_f":                   temp.Unwrap(),
+				"temp_c":                   temp.C().Unwrap(),
+				"temp_high_f":              high.Unwrap(),
+				"temp_high_c":              high.C().Unwrap(),
+				"temp_low_f":               low.Unwrap(),
+				"temp_low_c":               low.C().Unwrap(),
+				"humidity":                 f.RelativeHumidity,
+				"dew_point_f":              dewpoint.Unwrap(),
+				"dew_point_c":              dewpoint.C().Unwrap(),
+				"barometric_pressure_mb":   f.Pressure,
+				"barometric_pressure_inHg": pressure.InHg().Unwrap(),
+				"wind_speed_mph":           windSpeed.Unwrap(),
+				"wind_gust_mph":            windGust.Unwrap(),
+				"wind_direction":           f.WindDirection,
+				"wind_bearing":             f.WindBearing,
+				"visibility_mi":            visibility.Unwrap(),
+				"visibility_km":            visibility.Km().Unwrap(),
+				"pop":                      f.Pop,
+				"condition":                f.Condition,
+				"weather_symbol":           f.WeatherSymbol,
+				"sky":                      f.Sky,
+				"issued":                   issued.Format(time.RFC3339),
+			},
+			forecastTime,
+			fmt.Sprintf("weather_forecast/%s", horizon),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+func TestProcessForecasts(t *testing.T) {
+	thermostat := &ecobee.Thermostat{
+		Name:           "Home",
+		ThermostatTime: "2024-01-15 07:00:00",
+		UtcTime:        "2024-01-15 12:00:00",
+	}
+	thermostat.Weather.Forecasts = []ecobee.WeatherForecast{
+		{DateTime: "2024-01-15 07:00:00", Temperature: 305},
+		{DateTime: "not a time", Temperature: 310},
+		{DateTime: "2024-01-15 19:00:00", Temperature: 285},
+	}
+	issued := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	influx := &fakeWriteAPI{}
+	c := &connector{out: &output{influxWriteAPI: influx}}
+	if err := c.processForecasts(thermostat, issued, map[string]string{thermostatNameTag: "Home"}); err != nil {
+		t.Fatalf("processForecasts: %s", err)
+	}
+
+	points := influx.byMeasurement("ecobee_weather_forecast")
+	if len(points) != 2 {
+		t.Fatalf("wrote %d forecast points; want 2, skipping the malformed entry", len(points))
+	}
+	for i, want := range []struct {
+		ts      time.Time
+		horizon string
+		tempF   float64
+	}{
+		{time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), "0h", 30.5},
+		{time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC), "12h", 28.5},
+	} {
+		p := points[i]
+		if !p.Time().Equal(want.ts) {
+			t.Errorf("forecast %d time = %s; want %s", i, p.Time(), want.ts)
+		}
+		if horizon, _ := pointTag(p, "horizon"); horizon != want.horizon {
+			t.Errorf("forecast %d horizon = %s; want %s", i, horizon, want.horizon)
+		}
+		if tempF, _ := pointField(p, "temp_f"); tempF != want.tempF {
+			t.Errorf("forecast %d temp_f = %v; want %v", i, tempF, want.tempF)
+		}
+	}
+}
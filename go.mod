@@ -10,6 +10,7 @@ require (
 	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/golang/glog v1.2.5
 	github.com/influxdata/influxdb-client-go/v2 v2.14.0
+	golang.org/x/crypto v0.42.0
 	golang.org/x/oauth2 v0.30.0
 	golang.org/x/sync v0.17.0
 )
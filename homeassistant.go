@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// defaultHomeAssistantPrefix is Home Assistant's default MQTT discovery
+// prefix.
+const defaultHomeAssistantPrefix = "homeassistant"
+
+// haEntity is one of the connector's MQTT topics, announced to Home
+// Assistant as a sensor.
+type haEntity struct {
+	category    string // the topic's data category, e.g. runtime
+	field       string
+	name        string
+	deviceClass string
+	unit        string
+}
+
+var haEntities = []haEntity{
+	{"runtime", "temperature_f", "Temperature", "temperature", "°F"},
+	{"runtime", "humidity", "Humidity", "humidity", "%"},
+	{"runtime", "heat_set_point_f", "Heat set point", "temperature", "°F"},
+	{"runtime", "cool_set_point_f", "Cool set point", "temperature", "°F"},
+	{"runtime", "hvac_mode", "HVAC mode", "", ""},
+	{"weather", "outdoor_temp_f", "Outdoor temperature", "temperature", "°F"},
+	{"weather", "outdoor_humidity", "Outdoor humidity", "humidity", "%"},
+	{"device_info", "firmware_version", "Firmware version", "", ""},
+}
+
+// haDevice is the device block of a Home Assistant discovery config, which
+// groups the thermostat's entities into one device.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+}
+
+// haSensorConfig is the discovery config of a Home Assistant MQTT sensor.
+type haSensorConfig struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	UnitOfMeasurement string   `json:"unit_of_measurement,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+// homeAssistantDiscovery returns the discovery config of each of the
+// thermostat's Home Assistant sensors, keyed by the topic it's published to.
+// Each describes the thermostat's model, manufacturer and firmware version.
+func homeAssistantDiscovery(cfg Config, t *ecobee.Thermostat) map[string]haSensorConfig {
+	prefix := cfg.MQTT.HomeAssistantPrefix
+	if prefix == "" {
+		prefix = defaultHomeAssistantPrefix
+	}
+	nodeID := "ecobee_" + cfg.ThermostatID
+	manufacturer := t.Brand
+	if manufacturer == "" {
+		manufacturer = "ecobee"
+	}
+	device := haDevice{
+		Identifiers:  []string{nodeID},
+		Name:         t.Name,
+		Manufacturer: manufacturer,
+		Model:        t.ModelNumber,
+		SWVersion:    t.Version.ThermostatFirmwareVersion,
+	}
+
+	configs := make(map[string]haSensorConfig, len(haEntities))
+	for _, e := range haEntities {
+		objectID := e.category + "_" + e.field
+		configs[fmt.Sprintf("%s/sensor/%s/%s/config", prefix, nodeID, objectID)] = haSensorConfig{
+			Name:              e.name,
+			UniqueID:          nodeID + "_" + objectID,
+			StateTopic:        fmt.Sprintf("%s/%s/%s/%s", cfg.MQTT.TopicRoot, cfg.ThermostatID, e.category, e.field),
+			DeviceClass:       e.deviceClass,
+			UnitOfMeasurement: e.unit,
+			Device:            device,
+		}
+	}
+	return configs
+}
+
+// publishHomeAssistantDiscovery publishes the thermostat's Home Assistant
+// discovery configs, retained so that Home Assistant finds them whenever it
+// starts.
+func publishHomeAssistantDiscovery(client mqtt.Client, cfg Config, t *ecobee.Thermostat) error {
+	timeout := time.Duration(cfg.MQTT.TimeoutSeconds) * time.Second
+	if timeout == 0 {
+		timeout = 3 * time.Second // default timeout
+	}
+	for topic, c := range homeAssistantDiscovery(cfg, t) {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if err := publishToMQTT(client, topic, string(b), true, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,56 +6,83 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"math"
 	"os"
+	"os/signal"
 	"path"
-	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/avast/retry-go"
-	wx "github.com/cdzombak/libwx"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/influxdata/influxdb-client-go/v2"
 	influxdb2api "github.com/influxdata/influxdb-client-go/v2/api"
 
 	"ecobee_influx_connector/ecobee" // taken from https://github.com/rspier/go-ecobee and lightly customized
+	"ecobee_influx_connector/ecobee/ecobeetest"
 )
 
 // MQTTConfig describes the program's (optional) MQTT output configuration.
 type MQTTConfig struct {
-	Enabled        bool   `json:"enabled"`
-	Server         string `json:"server"`
-	Port           int    `json:"port,omitempty"`
-	Username       string `json:"username,omitempty"`
-	Password       string `json:"password,omitempty"`
-	TopicRoot      string `json:"topic_root"`
-	TimeoutSeconds int    `json:"timeout,omitempty"`
+	Enabled                bool   `json:"enabled"`
+	Server                 string `json:"server"`
+	Port                   int    `json:"port,omitempty"`
+	Username               string `json:"username,omitempty"`
+	Password               string `json:"password,omitempty"`
+	TopicRoot              string `json:"topic_root"`
+	TimeoutSeconds         int    `json:"timeout,omitempty"`
+	CommandsEnabled        bool   `json:"commands_enabled,omitempty"`        // accept commands via MQTT; see mqttCommands
+	HomeAssistantDiscovery bool   `json:"homeassistant_discovery,omitempty"` // publish Home Assistant discovery configs
+	HomeAssistantPrefix    string `json:"homeassistant_prefix,omitempty"`    // Home Assistant's discovery prefix; default "homeassistant"
+}
+
+// SensorConfig describes optional per-sensor configuration, keyed by sensor
+// ID in Config.Sensors.
+type SensorConfig struct {
+	// Alias, if set, is written as the sensor's sensor_alias tag, and used in
+	// place of the name set in the Ecobee app in MQTT topics.
+	Alias string `json:"alias,omitempty"`
+	// Room, Floor and Zone, if set, are written as tags and used as MQTT
+	// topic segments. Sensors in the same Zone are averaged into the
+	// ecobee_zone measurement.
+	Room  string `json:"room,omitempty"`
+	Floor string `json:"floor,omitempty"`
+	Zone  string `json:"zone,omitempty"`
 }
 
 // Config describes the ecobee_influx_connector program's configuration.
 // It is used to parse the configuration JSON file.
 type Config struct {
-	APIKey                    string     `json:"api_key"`
-	WorkDir                   string     `json:"work_dir,omitempty"`
-	ThermostatID              string     `json:"thermostat_id"`
-	InfluxServer              string     `json:"influx_server"`
-	InfluxOrg                 string     `json:"influx_org,omitempty"`
-	InfluxUser                string     `json:"influx_user,omitempty"`
-	InfluxPass                string     `json:"influx_password,omitempty"`
-	InfluxToken               string     `json:"influx_token,omitempty"`
-	InfluxBucket              string     `json:"influx_bucket"`
-	InfluxHealthCheckDisabled bool       `json:"influx_health_check_disabled"`
-	InfluxTimeoutSeconds      int        `json:"influx_timeout,omitempty"`
-	MQTT                      MQTTConfig `json:"mqtt"`
-	WriteHeatPump1            bool       `json:"write_heat_pump_1"`
-	WriteHeatPump2            bool       `json:"write_heat_pump_2"`
-	WriteAuxHeat1             bool       `json:"write_aux_heat_1"`
-	WriteAuxHeat2             bool       `json:"write_aux_heat_2"`
-	WriteCool1                bool       `json:"write_cool_1"`
-	WriteCool2                bool       `json:"write_cool_2"`
-	WriteHumidifier           bool       `json:"write_humidifier"`
-	WriteDehumidifier         bool       `json:"write_dehumidifier"`
-	AlwaysWriteWeather        bool       `json:"always_write_weather_as_current"`
+	APIKey                    string                  `json:"api_key"`
+	WorkDir                   string                  `json:"work_dir,omitempty"`
+	ThermostatID              string                  `json:"thermostat_id"`
+	EcobeeAPIURL              string                  `json:"ecobee_api_url,omitempty"`
+	EcobeeTimeoutSeconds      int                     `json:"ecobee_timeout,omitempty"`
+	InfluxServer              string                  `json:"influx_server"`
+	InfluxOrg                 string                  `json:"influx_org,omitempty"`
+	InfluxUser                string                  `json:"influx_user,omitempty"`
+	InfluxPass                string                  `json:"influx_password,omitempty"`
+	InfluxToken               string                  `json:"influx_token,omitempty"`
+	InfluxBucket              string                  `json:"influx_bucket"`
+	InfluxHealthCheckDisabled bool                    `json:"influx_health_check_disabled"`
+	InfluxTimeoutSeconds      int                     `json:"influx_timeout,omitempty"`
+	MQTT                      MQTTConfig              `json:"mqtt"`
+	WriteHeatPump1            bool                    `json:"write_heat_pump_1"`
+	WriteHeatPump2            bool                    `json:"write_heat_pump_2"`
+	WriteAuxHeat1             bool                    `json:"write_aux_heat_1"`
+	WriteAuxHeat2             bool                    `json:"write_aux_heat_2"`
+	WriteAuxHeat3             bool                    `json:"write_aux_heat_3"`
+	WriteCool1                bool                    `json:"write_cool_1"`
+	WriteCool2                bool                    `json:"write_cool_2"`
+	WriteHumidifier           bool                    `json:"write_humidifier"`
+	WriteDehumidifier         bool                    `json:"write_dehumidifier"`
+	WriteEconomizer           bool                    `json:"write_economizer"`
+	WriteVentilator           bool                    `json:"write_ventilator"`
+	AlwaysWriteWeather        bool                    `json:"always_write_weather_as_current"`
+	ArchiveResponses          bool                    `json:"archive_responses"`
+	ArchiveRetentionDays      int                     `json:"archive_retention_days,omitempty"`
+	StaleStatusMinutes        int                     `json:"stale_status_minutes,omitempty"`
+	Sensors                   map[string]SensorConfig `json:"sensors,omitempty"`
 }
 
 // TODO(cdzombak): config v2:
@@ -67,6 +94,7 @@ const (
 	source                       = "ecobee"
 	sourceTag                    = "data_source"
 	ecobeeWeatherMeasurementName = "ecobee_weather"
+	healthMeasurementName        = "ecobee_connector_health"
 )
 
 var version = "<dev>"
@@ -74,6 +102,25 @@ var version = "<dev>"
 func main() {
 	configFile := flag.String("config", "", "Configuration JSON file.")
 	listThermostats := flag.Bool("list-thermostats", false, "List available thermostats, then exit.")
+	authRequest := flag.Bool("auth-request", false, "Request an authorization PIN, store the pending code in work_dir, then exit.")
+	authComplete := flag.Bool("auth-complete", false, "Exchange the pending authorization code for credentials, then exit.")
+	ackRef := flag.String("acknowledge-alert", "", "Acknowledge the alert with the given acknowledgeRef, then exit.")
+	ackType := flag.String("ack-type", ecobee.AckTypeAccept, "Acknowledgement type for -acknowledge-alert: accept, decline, defer, or unacknowledged.")
+	remindMeLater := flag.Bool("remind-me-later", false, "With -acknowledge-alert, ask the thermostat to remind you about the alert later.")
+	dumpScheduleFlag := flag.Bool("dump-schedule", false, "Print the thermostat's climates and weekly schedule, then exit.")
+	scheduleFormat := flag.String("schedule-format", "table", "Output format for -dump-schedule: table or json.")
+	backupProgram := flag.Bool("backup-program", false, "Save the thermostat's program (schedule and climates) to a new backup file in work_dir, then exit.")
+	diffProgram := flag.String("diff-program", "", "Show how the thermostat's program differs from the given backup file, then exit.")
+	restoreProgram := flag.String("restore-program", "", "Restore the thermostat's program from the given backup file, after confirmation, then exit.")
+	setClimateSensorsRef := flag.String("set-climate-sensors", "", "Set which sensors participate in the climate with the given ref (e.g. sleep) to those given by -sensors, then exit.")
+	sensorList := flag.String("sensors", "", "With -set-climate-sensors, a comma-separated list of sensor names or IDs.")
+	renameSensorFlag := flag.String("rename-sensor", "", "Rename the sensor with the given name or ID to the name given by -name, then exit.")
+	newSensorName := flag.String("name", "", "With -rename-sensor, the sensor's new name.")
+	dryRun := flag.Bool("dry-run", false, "With -restore-program or -set-climate-sensors, show the changes without making them.")
+	assumeYes := flag.Bool("yes", false, "With -restore-program, don't ask for confirmation.")
+	force := flag.Bool("force", false, "With -restore-program, restore a backup taken from a different thermostat.")
+	replay := flag.String("replay", "", "Replay archived API responses from the given directory through the configured outputs, then exit.")
+	simulate := flag.Bool("simulate", false, "Run against a built-in fake Ecobee API serving a synthetic thermostat, instead of the real API.")
 	printVersion := flag.Bool("version", false, "Print version and exit.")
 	flag.Parse()
 
@@ -95,7 +142,7 @@ func main() {
 	if err = json.Unmarshal(cfgBytes, &config); err != nil {
 		log.Fatalf("Unable to parse config file '%s': %s", *configFile, err)
 	}
-	if config.APIKey == "" {
+	if config.APIKey == "" && !*simulate && *replay == "" {
 		log.Fatal("api_key must be set in the config file.")
 	}
 	if config.WorkDir == "" {
@@ -106,9 +153,62 @@ func main() {
 		config.WorkDir = wd
 	}
 
-	client := ecobee.NewClient(config.APIKey, path.Join(config.WorkDir, "ecobee-cred-cache"))
+	ecobeeTimeout := time.Duration(config.EcobeeTimeoutSeconds) * time.Second
+	if ecobeeTimeout == 0 {
+		ecobeeTimeout = 30 * time.Second // default timeout
+	}
+	ecobeeOpts := []ecobee.ClientOption{
+		ecobee.WithTimeout(ecobeeTimeout),
+		ecobee.WithUserAgent(fmt.Sprintf("ecobee_influx_connector/%s", version)),
+	}
+	if config.EcobeeAPIURL != "" {
+		ecobeeOpts = append(ecobeeOpts, ecobee.WithBaseURL(config.EcobeeAPIURL))
+	}
+	// The credential cache is encrypted if a key is supplied via the environment:
+	if key := os.Getenv(credCacheKeyEnvVar); key != "" {
+		ecobeeOpts = append(ecobeeOpts, ecobee.WithCacheKey(key))
+	}
+
+	credCachePath := path.Join(config.WorkDir, credCacheFileName)
+	authPendingPath := path.Join(config.WorkDir, authPendingFileName)
+
+	if *simulate {
+		sim := ecobeetest.NewServer()
+		defer sim.Close()
+		log.Printf("Simulating the Ecobee API at %s", sim.URL)
+		ecobeeOpts = append(ecobeeOpts, ecobee.WithBaseURL(sim.URL))
+		if config.APIKey == "" {
+			config.APIKey = "simulated"
+		}
+		config.ThermostatID = ecobeetest.ThermostatID
+		// Keep the real credential cache untouched, and authorize against
+		// the fake API up front; it approves every request.
+		credCachePath = path.Join(config.WorkDir, simCredCacheFileName)
+		authPendingPath = path.Join(config.WorkDir, simAuthPendingFileName)
+		pin, err := ecobee.Authorize(config.APIKey, ecobeeOpts...)
+		if err != nil {
+			log.Fatalf("Failed to authorize with simulated API: %s", err)
+		}
+		if err := ecobee.SaveToken(config.APIKey, credCachePath, pin.Code, ecobeeOpts...); err != nil {
+			log.Fatalf("Failed to authorize with simulated API: %s", err)
+		}
+	}
+
+	if *authRequest {
+		if err := requestAuthorization(config, authPendingPath, ecobeeOpts); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if *authComplete {
+		if err := completeAuthorization(config, authPendingPath, credCachePath, ecobeeOpts); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
 	if *listThermostats {
+		client := ecobee.NewClient(config.APIKey, credCachePath, ecobeeOpts...)
 		s := ecobee.Selection{
 			SelectionType: "registered",
 		}
@@ -126,6 +226,66 @@ func main() {
 		log.Fatalf("thermostat_id must be set in the config file.")
 	}
 
+	if *dumpScheduleFlag {
+		client := ecobee.NewClient(config.APIKey, credCachePath, ecobeeOpts...)
+		t, err := client.GetThermostat(config.ThermostatID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := dumpSchedule(os.Stdout, t.Program, *scheduleFormat); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if *backupProgram || *diffProgram != "" || *restoreProgram != "" {
+		client := ecobee.NewClient(config.APIKey, credCachePath, ecobeeOpts...)
+		if err := runProgramCommand(client, config, *backupProgram, *diffProgram, *restoreProgram, *dryRun, *assumeYes, *force); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if *setClimateSensorsRef != "" {
+		client := ecobee.NewClient(config.APIKey, credCachePath, ecobeeOpts...)
+		var sensors []string
+		if *sensorList != "" {
+			sensors = strings.Split(*sensorList, ",")
+		}
+		changes, err := setClimateSensors(client, config.ThermostatID, *setClimateSensorsRef, sensors, *dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(changes) == 0 {
+			fmt.Println("No changes needed.")
+		} else {
+			fmt.Println(formatChanges(changes))
+			if *dryRun {
+				fmt.Println("Dry run; no changes made.")
+			}
+		}
+		os.Exit(0)
+	}
+
+	if *renameSensorFlag != "" {
+		client := ecobee.NewClient(config.APIKey, credCachePath, ecobeeOpts...)
+		oldName, err := renameSensor(client, config.ThermostatID, *renameSensorFlag, *newSensorName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Renamed sensor '%s' to '%s'.\n", oldName, strings.TrimSpace(*newSensorName))
+		os.Exit(0)
+	}
+
+	if *ackRef != "" {
+		client := ecobee.NewClient(config.APIKey, credCachePath, ecobeeOpts...)
+		if err := client.Acknowledge(config.ThermostatID, *ackRef, *ackType, *remindMeLater); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Acknowledged alert %s (%s).\n", *ackRef, *ackType)
+		os.Exit(0)
+	}
+
 	var influxClient influxdb2.Client
 	var influxWriteAPI influxdb2api.WriteAPIBlocking
 	influxEnabled := config.InfluxServer != "" && config.InfluxBucket != ""
@@ -159,7 +319,25 @@ func main() {
 		log.Printf("InfluxDB is not configured, data will not be written to InfluxDB")
 	}
 
+	// When running as a service, there's nobody to enter a PIN; fail fast
+	// rather than blocking on stdin. Replaying doesn't use the API.
+	var client *ecobee.Client
+	if *replay == "" {
+		if hasToken, err := ecobee.HasCachedToken(credCachePath, ecobeeOpts...); err != nil {
+			log.Fatalf("Unable to use credential cache: %s", err)
+		} else if !hasToken {
+			log.Fatal(authRequiredMessage(credCachePath))
+		}
+		client = ecobee.NewClient(config.APIKey, credCachePath, append(ecobeeOpts, ecobee.NonInteractive())...)
+	}
+
 	var mqttClient mqtt.Client
+	commands := newMQTTCommands(config)
+	if client != nil && config.MQTT.CommandsEnabled {
+		commands.handle("acknowledge", acknowledgeHandler(client, config.ThermostatID))
+		commands.handle("set_climate_sensors", setClimateSensorsHandler(client, config.ThermostatID))
+		commands.handle("rename_sensor", renameSensorHandler(client, config.ThermostatID))
+	}
 	mqttEnabled := config.MQTT.Enabled
 	if mqttEnabled {
 		if config.MQTT.Server == "" || config.MQTT.TopicRoot == "" {
@@ -182,6 +360,9 @@ func main() {
 		opts.SetClientID(fmt.Sprintf("ecobee_influx_connector_%d", time.Now().Unix()))
 		opts.SetAutoReconnect(true)
 		opts.SetConnectRetry(true)
+		if client != nil && config.MQTT.CommandsEnabled {
+			opts.SetOnConnectHandler(commands.subscribe)
+		}
 
 		mqttClient = mqtt.NewClient(opts)
 		if token := mqttClient.Connect(); token.Wait() && token.Error() != nil {
@@ -196,363 +377,140 @@ func main() {
 		log.Fatalf("At least one output method (InfluxDB or MQTT) must be configured")
 	}
 
-	lastWrittenRuntimeInterval := 0
-	lastWrittenWeather := time.Time{}
-	lastWrittenSensors := time.Time{}
-
-	doUpdate := func() {
-		if err := retry.Do(
-			func() error {
-				t, err := client.GetThermostat(config.ThermostatID)
-				if err != nil {
-					return err
-				}
+	out := &output{
+		config:         config,
+		influxWriteAPI: influxWriteAPI,
+		influxTimeout:  influxTimeout,
+		mqttClient:     mqttClient,
+	}
+	// State (e.g. which alerts have been forwarded) is remembered across
+	// restarts, except when replaying or simulating, which shouldn't affect
+	// the real state:
+	stateDir := config.WorkDir
+	if *replay != "" || *simulate {
+		stateDir = ""
+	}
+	conn, err := newConnector(config, out, stateDir)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-				// Air-quality-related values are only in the current runtime,
-				// thus they need to be handled outside the extended runtime section
-				currentRuntimeReportTime, err := time.Parse("2006-01-02 15:04:05", t.Runtime.LastStatusModified)
-				if err != nil {
-					return err
-				}
+	if *replay != "" {
+		// Replayed weather should be written at the time it was observed:
+		conn.config.AlwaysWriteWeather = false
+		if err := replayArchive(*replay, conn); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
-				if err := retry.Do(func() error {
-					ctx, cancel := context.WithTimeout(context.Background(), influxTimeout)
-					defer cancel()
-
-					actualAQAccuracy := t.Runtime.ActualAQAccuracy
-					actualAQScore := t.Runtime.ActualAQScore
-					actualCO2 := t.Runtime.ActualCO2
-					actualVOC := t.Runtime.ActualVOC
-
-					fmt.Printf("Air quality at %s:\n", currentRuntimeReportTime)
-					fmt.Printf("\tcurrent co2: %d\n\tcurrent voc: %d\n",
-						actualCO2, actualVOC)
-
-					fields := map[string]interface{}{
-						"airquality_accuracy": actualAQAccuracy,
-						"airquality_score":    actualAQScore,
-						"co2":                 actualCO2,
-						"voc":                 actualVOC,
-					}
-
-					if influxEnabled {
-						if err := influxWriteAPI.WritePoint(ctx,
-							influxdb2.NewPoint(
-								"ecobee_air_quality",
-								map[string]string{thermostatNameTag: t.Name}, // tags
-								fields,
-								currentRuntimeReportTime,
-							)); err != nil {
-							return err
-						}
-					}
-
-					// Publish to MQTT if enabled
-					if config.MQTT.Enabled {
-						if err := publishFieldsToMQTT(mqttClient, config, "sensor", fields); err != nil {
-							return err
-						}
-					}
-
-					return nil
-				}, retry.Attempts(3), retry.Delay(1*time.Second)); err != nil {
-					return err
-				}
+	// Refresh the access token ahead of its expiry, and report whether the
+	// connector's authorization is still healthy:
+	reportAuthHealth := func(err error) {
+		state := "ok"
+		errMsg := ""
+		if err != nil {
+			state = "error"
+			errMsg = err.Error()
+			if ecobee.IsAuthRevoked(err) {
+				state = "revoked"
+				log.Printf("Ecobee authorization has been revoked or has expired; the connector must be re-authorized. %s",
+					authRequiredMessage(credCachePath))
+			} else {
+				log.Printf("failed to refresh Ecobee access token: %s", err)
+			}
+		}
+		if err := out.write(
+			healthMeasurementName,
+			// Until the first poll, the thermostat's name isn't known:
+			map[string]string{thermostatNameTag: conn.thermostatName(config.ThermostatID)},
+			map[string]any{
+				"auth_ok":    err == nil,
+				"auth_state": state,
+				"auth_error": errMsg,
+			},
+			time.Now(),
+			"health",
+		); err != nil {
+			log.Printf("failed to write auth health: %s", err)
+		}
+	}
+	// Stop polling (and cancel any in-flight request) on SIGINT/SIGTERM:
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-				latestRuntimeInterval := t.ExtendedRuntime.RuntimeInterval
-				log.Printf("latest runtime interval available is %d\n", latestRuntimeInterval)
+	go client.RefreshTokenAhead(ctx, 5*time.Minute, 1*time.Minute, reportAuthHealth)
 
-				// In the absence of a time zone indicator, Parse returns a time in UTC.
-				baseReportTime, err := time.Parse("2006-01-02 15:04:05", t.ExtendedRuntime.LastReadingTimestamp)
-				if err != nil {
-					return err
-				}
+	watcher, err := newConfigWatcher(client, config, out, stateDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go watcher.run(ctx)
 
-				for i := 0; i < 3; i++ {
-					reportTime := baseReportTime
-					if i == 0 {
-						reportTime = reportTime.Add(-5 * time.Minute)
-					}
-					if i == 2 {
-						reportTime = reportTime.Add(5 * time.Minute)
-					}
-
-					currentTemp := wx.TempF(float64(t.ExtendedRuntime.ActualTemperature[i]) / 10.0)
-					currentHumidity := t.ExtendedRuntime.ActualHumidity[i]
-					heatSetPoint := wx.TempF(float64(t.ExtendedRuntime.DesiredHeat[i]) / 10.0)
-					coolSetPoint := wx.TempF(float64(t.ExtendedRuntime.DesiredCool[i]) / 10.0)
-					humiditySetPoint := t.ExtendedRuntime.DesiredHumidity[i]
-					demandMgmtOffset := wx.TempF(float64(t.ExtendedRuntime.DmOffset[i]) / 10.0)
-					hvacMode := t.ExtendedRuntime.HvacMode[i] // string :(
-					heatPump1RunSec := t.ExtendedRuntime.HeatPump1[i]
-					heatPump2RunSec := t.ExtendedRuntime.HeatPump2[i]
-					auxHeat1RunSec := t.ExtendedRuntime.AuxHeat1[i]
-					auxHeat2RunSec := t.ExtendedRuntime.AuxHeat2[i]
-					cool1RunSec := t.ExtendedRuntime.Cool1[i]
-					cool2RunSec := t.ExtendedRuntime.Cool2[i]
-					fanRunSec := t.ExtendedRuntime.Fan[i]
-					humidifierRunSec := t.ExtendedRuntime.Humidifier[i]
-					dehumidifierRunSec := t.ExtendedRuntime.Dehumidifier[i]
-
-					fmt.Printf("Thermostat conditions at %s:\n", reportTime)
-					fmt.Printf("\tcurrent temperature: %.1f degF (%.1f degC)\n\theat set point: %.1f degF (%.1f degC)"+
-						"\n\tcool set point: %.1f degF (%.1f degC)\n\tdemand management offset: %.1f (%.1f degC)\n",
-						currentTemp, currentTemp.C(), heatSetPoint, heatSetPoint.C(),
-						coolSetPoint, coolSetPoint.C(), demandMgmtOffset, demandMgmtOffset.C())
-					fmt.Printf("\tcurrent humidity: %d%%\n\thumidity set point: %d\n\tHVAC mode: %s\n",
-						currentHumidity, humiditySetPoint, hvacMode)
-					fmt.Printf("\tfan runtime: %d seconds\n\thumidifier runtime: %d seconds\n\tdehumidifier runtime: %d seconds\n",
-						fanRunSec, humidifierRunSec, dehumidifierRunSec)
-					fmt.Printf("\theat pump 1 runtime: %d seconds\n\theat pump 2 runtime: %d seconds\n",
-						heatPump1RunSec, heatPump2RunSec)
-					fmt.Printf("\theat 1 runtime: %d seconds\n\theat 2 runtime: %d seconds\n",
-						auxHeat1RunSec, auxHeat2RunSec)
-					fmt.Printf("\tcool 1 runtime: %d seconds\n\tcool 2 runtime: %d seconds\n",
-						cool1RunSec, cool2RunSec)
-
-					if latestRuntimeInterval != lastWrittenRuntimeInterval {
-						if err := retry.Do(func() error {
-							ctx, cancel := context.WithTimeout(context.Background(), influxTimeout)
-							defer cancel()
-							fields := map[string]interface{}{
-								"temperature":          currentTemp.Unwrap(),
-								"temperature_f":        currentTemp.Unwrap(),
-								"temperature_c":        currentTemp.C().Unwrap(),
-								"humidity":             currentHumidity,
-								"heat_set_point":       heatSetPoint.Unwrap(),
-								"heat_set_point_f":     heatSetPoint.Unwrap(),
-								"heat_set_point_c":     heatSetPoint.C().Unwrap(),
-								"cool_set_point":       coolSetPoint.Unwrap(),
-								"cool_set_point_f":     coolSetPoint.Unwrap(),
-								"cool_set_point_c":     coolSetPoint.C().Unwrap(),
-								"demand_mgmt_offset":   demandMgmtOffset.Unwrap(),
-								"demand_mgmt_offset_f": demandMgmtOffset.Unwrap(),
-								"demand_mgmt_offset_c": demandMgmtOffset.C().Unwrap(),
-								"fan_run_time":         fanRunSec,
-							}
-							if config.WriteHumidifier || config.WriteDehumidifier {
-								fields["humidity_set_point"] = humiditySetPoint
-							}
-							if config.WriteHumidifier {
-								fields["humidifier_run_time"] = humidifierRunSec
-							}
-							if config.WriteDehumidifier {
-								fields["dehumidifier_run_time"] = dehumidifierRunSec
-							}
-							if config.WriteAuxHeat1 {
-								fields["aux_heat_1_run_time"] = auxHeat1RunSec
-							}
-							if config.WriteAuxHeat2 {
-								fields["aux_heat_2_run_time"] = auxHeat2RunSec
-							}
-							if config.WriteHeatPump1 {
-								fields["heat_pump_1_run_time"] = heatPump1RunSec
-							}
-							if config.WriteHeatPump2 {
-								fields["heat_pump_2_run_time"] = heatPump2RunSec
-							}
-							if config.WriteCool1 {
-								fields["cool_1_run_time"] = cool1RunSec
-							}
-							if config.WriteCool2 {
-								fields["cool_2_run_time"] = cool2RunSec
-							}
-							if influxEnabled {
-								if err := influxWriteAPI.WritePoint(ctx,
-									influxdb2.NewPoint(
-										"ecobee_runtime",
-										map[string]string{thermostatNameTag: t.Name},
-										fields,
-										reportTime,
-									)); err != nil {
-									return err
-								}
-							}
-
-							// Publish to MQTT if enabled
-							if config.MQTT.Enabled {
-								if err := publishFieldsToMQTT(mqttClient, config, "runtime", fields); err != nil {
-									return err
-								}
-							}
-
-							return nil
-						}, retry.Attempts(3), retry.Delay(1*time.Second)); err != nil {
-							return err
-						}
-					}
-				}
-				lastWrittenRuntimeInterval = latestRuntimeInterval
+	deviceWatcher, err := newDeviceInfoWatcher(client, config, out, stateDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go deviceWatcher.run(ctx)
 
-				// assume t.LastModified for these:
-				sensorTime, err := time.Parse("2006-01-02 15:04:05", t.UtcTime)
-				if err != nil {
-					return err
-				}
-				for _, sensor := range t.RemoteSensors {
-					name := sensor.Name
-					var temp wx.TempF
-					var presence, presenceSupported bool
-					for _, c := range sensor.Capability {
-						if c.Type == "temperature" {
-							tempInt, err := strconv.Atoi(c.Value)
-							if err != nil {
-								log.Printf("error reading temp '%s' for sensor %s: %s", c.Value, sensor.Name, err)
-							} else {
-								temp = wx.TempF(float64(tempInt) / 10.0)
-							}
-						} else if c.Type == "occupancy" {
-							presenceSupported = true
-							presence = c.Value == "true"
-						}
-					}
-					fmt.Printf("Sensor '%s' at %s:\n", name, sensorTime)
-					fmt.Printf("\ttemperature: %.1f degF (%.1f degC)\n", temp, temp.C())
-					if presenceSupported {
-						fmt.Printf("\toccupied: %t\n", presence)
-					}
-
-					if temp == 0.0 {
-						// no temp reading from this sensor, so skip writing it to Influx
-						continue
-					}
-
-					if sensorTime != lastWrittenSensors {
-						if err := retry.Do(func() error {
-							ctx, cancel := context.WithTimeout(context.Background(), influxTimeout)
-							defer cancel()
-							fields := map[string]interface{}{
-								"temperature":   temp.Unwrap(),
-								"temperature_f": temp.Unwrap(),
-								"temperature_c": temp.C().Unwrap(),
-							}
-							if presenceSupported {
-								fields["occupied"] = presence
-							}
-							if influxEnabled {
-								if err := influxWriteAPI.WritePoint(ctx,
-									influxdb2.NewPoint(
-										"ecobee_sensor",
-										map[string]string{
-											thermostatNameTag: t.Name,
-											"sensor_name":     sensor.Name,
-											"sensor_id":       sensor.ID,
-										}, // tags
-										fields,
-										sensorTime,
-									)); err != nil {
-									return err
-								}
-							}
-
-							if config.MQTT.Enabled {
-								sensorPrefix := fmt.Sprintf("sensor/%s", sensor.Name)
-								if err := publishFieldsToMQTT(mqttClient, config, sensorPrefix, fields); err != nil {
-									return err
-								}
-							}
-
-							return nil
-						}, retry.Attempts(3), retry.Delay(1*time.Second)); err != nil {
-							return err
-						}
-					}
-				}
-				lastWrittenSensors = sensorTime
+	var archive *responseArchive
+	if config.ArchiveResponses {
+		archive = newResponseArchive(config)
+		log.Printf("Archiving API responses to %s", archive.dir)
+	}
 
-				weatherTime, err := time.Parse("2006-01-02 15:04:05", t.Weather.Timestamp)
+	doUpdate := func() {
+		// The most recent response is archived once per poll, even if
+		// processing it was retried or failed:
+		var raw []byte
+		var fetchedAt time.Time
+		err := retry.Do(
+			func() error {
+				t, body, err := client.GetThermostatRawContext(ctx, config.ThermostatID)
 				if err != nil {
 					return err
 				}
-				outdoorTemp := wx.TempF(float64(t.Weather.Forecasts[0].Temperature) / 10.0)
-				pressureMillibar := wx.PressureMb(t.Weather.Forecasts[0].Pressure)
-				outdoorHumidity := wx.ClampedRelHumidity(t.Weather.Forecasts[0].RelativeHumidity)
-				dewpoint := wx.TempF(float64(t.Weather.Forecasts[0].Dewpoint) / 10.0)
-				windSpeedMph := wx.SpeedMph(t.Weather.Forecasts[0].WindSpeed)
-				windBearing := t.Weather.Forecasts[0].WindBearing
-				visibilityMeters := wx.Meter(t.Weather.Forecasts[0].Visibility)
-				visibilityMiles := visibilityMeters.Miles()
-				windChill := wx.WindChillF(outdoorTemp, windSpeedMph)
-				weatherSymbol := t.Weather.Forecasts[0].WeatherSymbol
-				sky := t.Weather.Forecasts[0].Sky
-
-				fmt.Printf("Weather at %s:\n", weatherTime)
-				fmt.Printf("\ttemperature: %.1f degF (%.1f degC)\n\tpressure: %.0f mb\n\thumidity: %d%%\n\tdew point: %.1f degF (%.1f degC)",
-					outdoorTemp, outdoorTemp.C(), pressureMillibar, outdoorHumidity, dewpoint, dewpoint.C())
-				fmt.Printf("\n\twind: %d at %.0f mph\n\twind chill: %.1f degF\n\tvisibility: %.1f miles\nweather symbol: %d\nsky: %d",
-					windBearing, windSpeedMph, windChill, visibilityMiles, weatherSymbol, sky)
-
-				if weatherTime != lastWrittenWeather || config.AlwaysWriteWeather {
-					if err := retry.Do(func() error {
-						ctx, cancel := context.WithTimeout(context.Background(), influxTimeout)
-						defer cancel()
-						pointTime := weatherTime
-						if config.AlwaysWriteWeather {
-							pointTime = time.Now()
-						}
-						fields := map[string]interface{}{
-							"outdoor_temp":                    outdoorTemp.Unwrap(),
-							"outdoor_temp_f":                  outdoorTemp.Unwrap(),
-							"outdoor_temp_c":                  outdoorTemp.C().Unwrap(),
-							"outdoor_humidity":                outdoorHumidity.Unwrap(),
-							"barometric_pressure_mb":          int(math.Round(pressureMillibar.Unwrap())), // we get int precision from Ecobee, and historically this is written as int
-							"barometric_pressure_inHg":        pressureMillibar.InHg().Unwrap(),
-							"dew_point":                       dewpoint.Unwrap(),
-							"dew_point_f":                     dewpoint.Unwrap(),
-							"dew_point_c":                     dewpoint.C().Unwrap(),
-							"wind_speed":                      int(math.Round(windSpeedMph.Unwrap())), // we get int precision from Ecobee, and historically this is written as int
-							"wind_speed_mph":                  windSpeedMph.Unwrap(),
-							"wind_bearing":                    windBearing,
-							"visibility_mi":                   visibilityMiles.Unwrap(),
-							"visibility_km":                   visibilityMiles.Km().Unwrap(),
-							"recommended_max_indoor_humidity": wx.IndoorHumidityRecommendationF(outdoorTemp).Unwrap(),
-							"wind_chill_f":                    windChill.Unwrap(),
-							"wind_chill_c":                    windChill.C().Unwrap(),
-							"weather_symbol":                  weatherSymbol,
-							"sky":                             sky,
-						}
-						if influxEnabled {
-							if err := influxWriteAPI.WritePoint(ctx,
-								influxdb2.NewPoint(
-									ecobeeWeatherMeasurementName,
-									map[string]string{ // tags
-										thermostatNameTag: t.Name,
-										sourceTag:         source,
-									},
-									fields,
-									pointTime,
-								)); err != nil {
-								return err
-							}
-						}
-
-						if config.MQTT.Enabled {
-							if err := publishFieldsToMQTT(mqttClient, config, "weather", fields); err != nil {
-								return err
-							}
-						}
-
-						lastWrittenWeather = weatherTime
-						return nil
-					}, retry.Attempts(3), retry.Delay(1*time.Second)); err != nil {
-						return err
-					}
-				}
-
-				return nil
+				raw, fetchedAt = body, time.Now()
+				return conn.process(t)
 			},
 			retry.Attempts(3),
 			retry.Delay(5*time.Second),
-		); err != nil {
+			retry.RetryIf(ecobee.IsRetryable),
+			retry.LastErrorOnly(true),
+			retry.Context(ctx),
+		)
+		if archive != nil && raw != nil {
+			if err := archive.save(raw, fetchedAt); err != nil {
+				log.Printf("failed to archive API response: %s", err)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if ecobee.IsAuthRevoked(err) {
+				// Keep running so the health alert stays visible; the
+				// connector resumes once it's been re-authorized.
+				reportAuthHealth(err)
+				return
+			}
 			log.Fatal(err)
 		}
 	}
 
 	doUpdate()
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-time.Tick(5 * time.Minute):
+		case <-ticker.C:
 			doUpdate()
+		case <-ctx.Done():
+			log.Printf("Shutting down")
+			if mqttClient != nil {
+				mqttClient.Disconnect(250)
+			}
+			return
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -19,14 +20,28 @@ func publishFieldsToMQTT(client mqtt.Client, cfg Config, topicPrefix string, fie
 		topic := fmt.Sprintf("%s/%s/%s/%s", cfg.MQTT.TopicRoot, cfg.ThermostatID, topicPrefix, fieldName)
 		v := value
 		eg.Go(func() error {
-			return publishToMQTT(client, topic, v, timeout)
+			return publishToMQTT(client, topic, v, false, timeout)
 		})
 	}
 	return eg.Wait()
 }
 
-func publishToMQTT(client mqtt.Client, topic string, value any, timeout time.Duration) error {
-	token := client.Publish(topic, 0, false, fmt.Sprintf("%v", value))
+// publishJSONToMQTT publishes v, encoded as JSON, as a single message to
+// <topic_root>/<thermostat_id>/<topicSuffix>.
+func publishJSONToMQTT(client mqtt.Client, cfg Config, topicSuffix string, v any) error {
+	timeout := time.Duration(cfg.MQTT.TimeoutSeconds) * time.Second
+	if timeout == 0 {
+		timeout = 3 * time.Second // default timeout
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return publishToMQTT(client, fmt.Sprintf("%s/%s/%s", cfg.MQTT.TopicRoot, cfg.ThermostatID, topicSuffix), string(b), false, timeout)
+}
+
+func publishToMQTT(client mqtt.Client, topic string, value any, retained bool, timeout time.Duration) error {
+	token := client.Publish(topic, 0, retained, fmt.Sprintf("%v", value))
 	if !token.WaitTimeout(timeout) {
 		return fmt.Errorf("timeout publishing to MQTT topic '%s'", topic)
 	}
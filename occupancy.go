@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const occupancyStateFileName = "ecobee-occupancy-state.json"
+
+// maxOccupancyGap is the longest time between two sensor readings across
+// which occupancy is assumed to have been unchanged. Longer gaps (e.g. while
+// the connector was stopped) aren't counted towards occupied minutes.
+const maxOccupancyGap = 30 * time.Minute
+
+// occupancyState records open occupancy sessions and today's occupied
+// minutes, so that they survive restarts.
+type occupancyState struct {
+	Open     map[string]time.Time `json:"open"` // session start, by sensor ID
+	LastPoll time.Time            `json:"last_poll"`
+	Occupied map[string]bool      `json:"occupied"` // rooms occupied at LastPoll
+	Day      string               `json:"day"`      // thermostat-local date Minutes are for
+	Minutes  map[string]float64   `json:"minutes"`  // occupied minutes on Day, by room
+}
+
+func newOccupancyState(stateDir string) (*occupancyState, error) {
+	s := &occupancyState{}
+	if stateDir != "" {
+		if err := loadState(stateDir, occupancyStateFileName, s); err != nil {
+			return nil, err
+		}
+	}
+	if s.Open == nil {
+		s.Open = make(map[string]time.Time)
+	}
+	if s.Minutes == nil {
+		s.Minutes = make(map[string]float64)
+	}
+	return s, nil
+}
+
+// clone returns a deep copy of the state.
+func (s *occupancyState) clone() *occupancyState {
+	c := *s
+	c.Open = make(map[string]time.Time, len(s.Open))
+	for k, v := range s.Open {
+		c.Open[k] = v
+	}
+	c.Occupied = make(map[string]bool, len(s.Occupied))
+	for k, v := range s.Occupied {
+		c.Occupied[k] = v
+	}
+	c.Minutes = make(map[string]float64, len(s.Minutes))
+	for k, v := range s.Minutes {
+		c.Minutes[k] = v
+	}
+	return &c
+}
+
+// sensorRoom returns the room configured for the sensor, or its name if it
+// has none.
+func (c *connector) sensorRoom(sensor ecobee.RemoteSensor) string {
+	if room := c.config.Sensors[sensor.ID].Room; room != "" {
+		return room
+	}
+	return c.sensorName(sensor)
+}
+
+// localMidnight returns the start of the thermostat-local day containing ts,
+// and the start of the next day, in UTC.
+func localMidnight(ts time.Time, offset time.Duration) (time.Time, time.Time) {
+	y, m, d := ts.Add(offset).Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Add(-offset)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// processOccupancy tracks occupancy sessions for each sensor which reports
+// occupancy, writing each session to ecobee_occupancy_session when it ends,
+// and writes the minutes each room has been occupied today to
+// ecobee_occupancy_daily. A room is occupied while any of its sensors
+// detects occupancy.
+//
+// The state is updated on a copy, which replaces c.occupancy only once every
+// point has been written, so that a poll which is retried after a failed
+// write doesn't count the same minutes twice.
+func (c *connector) processOccupancy(t *ecobee.Thermostat, now time.Time) error {
+	s := c.occupancy.clone()
+	gap := now.Sub(s.LastPoll)
+	longGap := !s.LastPoll.IsZero() && gap > maxOccupancyGap
+	occupied := make(map[string]bool)
+	for _, sensor := range t.RemoteSensors {
+		o, ok := sensorFields(sensor)["occupied"].(bool)
+		if !ok {
+			continue
+		}
+		room := c.sensorRoom(sensor)
+		occupied[room] = occupied[room] || o
+		if _, ok := s.Minutes[room]; !ok {
+			s.Minutes[room] = 0
+		}
+
+		start, open := s.Open[sensor.ID]
+		switch {
+		case open && longGap:
+			// Occupancy during the gap is unknown, so the session ends at
+			// the last reading, and a new one starts now if still occupied.
+			if err := c.writeOccupancySession(t, sensor, start, s.LastPoll); err != nil {
+				return err
+			}
+			delete(s.Open, sensor.ID)
+			if o {
+				s.Open[sensor.ID] = now
+			}
+		case o && !open:
+			s.Open[sensor.ID] = now
+		case !o && open:
+			if err := c.writeOccupancySession(t, sensor, start, now); err != nil {
+				return err
+			}
+			delete(s.Open, sensor.ID)
+		}
+	}
+
+	offset := thermostatUTCOffset(t)
+	if !s.LastPoll.IsZero() && gap > 0 && !longGap {
+		from := s.LastPoll
+		for from.Before(now) {
+			dayStart, nextDay := localMidnight(from, offset)
+			to := now
+			if nextDay.Before(to) {
+				to = nextDay
+			}
+			if err := c.startOccupancyDay(t, s, dayStart, offset); err != nil {
+				return err
+			}
+			for room, o := range s.Occupied {
+				if o {
+					s.Minutes[room] += to.Sub(from).Minutes()
+				}
+			}
+			from = to
+		}
+	}
+	dayStart, _ := localMidnight(now, offset)
+	if err := c.startOccupancyDay(t, s, dayStart, offset); err != nil {
+		return err
+	}
+	if err := c.writeOccupancyDaily(t, s, dayStart); err != nil {
+		return err
+	}
+
+	s.Occupied = occupied
+	s.LastPoll = now
+	c.occupancy = s
+	if c.stateDir != "" {
+		if err := saveState(c.stateDir, occupancyStateFileName, s); err != nil {
+			log.Printf("failed to save occupancy state: %s", err)
+		}
+	}
+	return nil
+}
+
+// startOccupancyDay resets the daily occupied minutes if dayStart begins a
+// new day, first writing the final totals for the previous day.
+func (c *connector) startOccupancyDay(t *ecobee.Thermostat, s *occupancyState, dayStart time.Time, offset time.Duration) error {
+	day := dayStart.Add(offset).Format("2006-01-02")
+	if s.Day == day {
+		return nil
+	}
+	if prev, err := time.Parse("2006-01-02", s.Day); err == nil {
+		if err := c.writeOccupancyDaily(t, s, prev.Add(-offset)); err != nil {
+			return err
+		}
+	}
+	s.Day = day
+	for room := range s.Minutes {
+		s.Minutes[room] = 0
+	}
+	return nil
+}
+
+// writeOccupancyDaily writes each room's occupied minutes, timestamped at the
+// start of the day they're for, so that each poll overwrites the day's point.
+func (c *connector) writeOccupancyDaily(t *ecobee.Thermostat, s *occupancyState, dayStart time.Time) error {
+	rooms := make([]string, 0, len(s.Minutes))
+	for room := range s.Minutes {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	for _, room := range rooms {
+		minutes := s.Minutes[room]
+		fmt.Printf("Room '%s' occupied for %.0f minutes on %s\n", room, minutes, s.Day)
+		if err := c.out.write(
+			"ecobee_occupancy_daily",
+			map[string]string{
+				thermostatNameTag: t.Name,
+				"room":            room,
+			},
+			map[string]any{
+				"occupied_minutes": minutes,
+			},
+			dayStart,
+			fmt.Sprintf("occupancy_daily/%s", room),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOccupancySession writes a completed occupancy session, timestamped at
+// its start.
+func (c *connector) writeOccupancySession(t *ecobee.Thermostat, sensor ecobee.RemoteSensor, start, end time.Time) error {
+	name := c.sensorName(sensor)
+	duration := end.Sub(start)
+	fmt.Printf("Sensor '%s' was occupied from %s to %s (%s)\n", name, start, end, duration)
+	tags := map[string]string{thermostatNameTag: t.Name}
+	c.sensorTags(sensor, tags)
+	return c.out.write(
+		"ecobee_occupancy_session",
+		tags,
+		map[string]any{
+			"start":            start.Format(time.RFC3339),
+			"end":              end.Format(time.RFC3339),
+			"duration_minutes": duration.Minutes(),
+			"ecobee_name":      sensor.Name,
+		},
+		start,
+		fmt.Sprintf("occupancy_session/%s", name),
+	)
+}
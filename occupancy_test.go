@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+func TestLocalMidnight(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		ts         time.Time
+		offset     time.Duration
+		start, end time.Time
+	}{
+		{
+			name:   "UTC",
+			ts:     time.Date(2024, 1, 15, 13, 30, 0, 0, time.UTC),
+			offset: 0,
+			start:  time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			end:    time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "west of UTC, before UTC midnight",
+			ts:     time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC),
+			offset: -5 * time.Hour,
+			start:  time.Date(2024, 1, 15, 5, 0, 0, 0, time.UTC),
+			end:    time.Date(2024, 1, 16, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "west of UTC, after UTC midnight",
+			ts:     time.Date(2024, 1, 16, 3, 0, 0, 0, time.UTC),
+			offset: -5 * time.Hour,
+			start:  time.Date(2024, 1, 15, 5, 0, 0, 0, time.UTC),
+			end:    time.Date(2024, 1, 16, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "east of UTC",
+			ts:     time.Date(2024, 1, 15, 20, 0, 0, 0, time.UTC),
+			offset: 5*time.Hour + 30*time.Minute,
+			start:  time.Date(2024, 1, 15, 18, 30, 0, 0, time.UTC),
+			end:    time.Date(2024, 1, 16, 18, 30, 0, 0, time.UTC),
+		},
+		{
+			name:   "local midnight exactly",
+			ts:     time.Date(2024, 1, 15, 5, 0, 0, 0, time.UTC),
+			offset: -5 * time.Hour,
+			start:  time.Date(2024, 1, 15, 5, 0, 0, 0, time.UTC),
+			end:    time.Date(2024, 1, 16, 5, 0, 0, 0, time.UTC),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end := localMidnight(tc.ts, tc.offset)
+			if !start.Equal(tc.start) || !end.Equal(tc.end) {
+				t.Errorf("localMidnight(%s, %s) = %s, %s; want %s, %s",
+					tc.ts, tc.offset, start, end, tc.start, tc.end)
+			}
+		})
+	}
+}
+
+// occupancyPoll is a poll of a thermostat whose sensors rs:100 (in the
+// bedroom) and rs:101 (in the office) report the given occupancy. A sensor
+// left out of occupied reports no occupancy capability.
+type occupancyPoll struct {
+	at       string // UTC, "2006-01-02 15:04"
+	occupied map[string]bool
+	restart  bool // restart the connector, reloading its state, before this poll
+}
+
+func (p occupancyPoll) thermostat(t *testing.T) *ecobee.Thermostat {
+	t.Helper()
+	at := occupancyTime(t, p.at)
+	thermostat := &ecobee.Thermostat{
+		Name:           "Home",
+		ThermostatTime: at.Format("2006-01-02 15:04:05"),
+		UtcTime:        at.Format("2006-01-02 15:04:05"),
+	}
+	for _, sensor := range []ecobee.RemoteSensor{{ID: "rs:100", Name: "Bedroom"}, {ID: "rs:101", Name: "Office"}} {
+		if o, ok := p.occupied[sensor.ID]; ok {
+			sensor.Capability = []ecobee.RemoteSensorCapability{{ID: "2", Type: "occupancy", Value: fmt.Sprint(o)}}
+		}
+		thermostat.RemoteSensors = append(thermostat.RemoteSensors, sensor)
+	}
+	return thermostat
+}
+
+func occupancyTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	ts, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// occupancySession is an ecobee_occupancy_session point.
+type occupancySession struct {
+	sensorID string
+	start    time.Time
+	minutes  float64
+}
+
+func TestProcessOccupancy(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		polls    []occupancyPoll
+		sessions []occupancySession
+		daily    map[string]float64 // final occupied minutes, by "<day> <room>"
+		open     map[string]string  // open sessions in the saved state, by sensor ID
+	}{
+		{
+			name: "session opens and closes",
+			polls: []occupancyPoll{
+				{at: "2024-01-15 10:00", occupied: map[string]bool{"rs:100": true, "rs:101": false}},
+				{at: "2024-01-15 10:10", occupied: map[string]bool{"rs:100": true, "rs:101": false}},
+				{at: "2024-01-15 10:25", occupied: map[string]bool{"rs:100": false, "rs:101": false}},
+			},
+			sessions: []occupancySession{{"rs:100", occupancyTime(t, "2024-01-15 10:00"), 25}},
+			daily:    map[string]float64{"2024-01-15 Bedroom": 25, "2024-01-15 Office": 0},
+			open:     map[string]string{},
+		},
+		{
+			name: "session still open",
+			polls: []occupancyPoll{
+				{at: "2024-01-15 10:00", occupied: map[string]bool{"rs:100": false}},
+				{at: "2024-01-15 10:05", occupied: map[string]bool{"rs:100": true}},
+				{at: "2024-01-15 10:20", occupied: map[string]bool{"rs:100": true}},
+			},
+			daily: map[string]float64{"2024-01-15 Bedroom": 15},
+			open:  map[string]string{"rs:100": "2024-01-15 10:05"},
+		},
+		{
+			name: "day rollover",
+			polls: []occupancyPoll{
+				{at: "2024-01-15 23:40", occupied: map[string]bool{"rs:101": true}},
+				{at: "2024-01-15 23:55", occupied: map[string]bool{"rs:101": true}},
+				{at: "2024-01-16 00:10", occupied: map[string]bool{"rs:101": true}},
+				{at: "2024-01-16 00:20", occupied: map[string]bool{"rs:101": false}},
+			},
+			sessions: []occupancySession{{"rs:101", occupancyTime(t, "2024-01-15 23:40"), 40}},
+			daily:    map[string]float64{"2024-01-15 Office": 20, "2024-01-16 Office": 20},
+			open:     map[string]string{},
+		},
+		{
+			name: "long gap splits the session",
+			polls: []occupancyPoll{
+				{at: "2024-01-15 09:50", occupied: map[string]bool{"rs:100": true}},
+				{at: "2024-01-15 10:00", occupied: map[string]bool{"rs:100": true}},
+				{at: "2024-01-15 11:00", occupied: map[string]bool{"rs:100": true}},
+				{at: "2024-01-15 11:05", occupied: map[string]bool{"rs:100": false}},
+			},
+			sessions: []occupancySession{
+				{"rs:100", occupancyTime(t, "2024-01-15 09:50"), 10},
+				{"rs:100", occupancyTime(t, "2024-01-15 11:00"), 5},
+			},
+			daily: map[string]float64{"2024-01-15 Bedroom": 15},
+			open:  map[string]string{},
+		},
+		{
+			name: "open session survives a restart",
+			polls: []occupancyPoll{
+				{at: "2024-01-15 10:00", occupied: map[string]bool{"rs:100": true}},
+				{at: "2024-01-15 10:10", occupied: map[string]bool{"rs:100": true}, restart: true},
+				{at: "2024-01-15 10:15", occupied: map[string]bool{"rs:100": false}},
+			},
+			sessions: []occupancySession{{"rs:100", occupancyTime(t, "2024-01-15 10:00"), 15}},
+			daily:    map[string]float64{"2024-01-15 Bedroom": 15},
+			open:     map[string]string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			influx := &fakeWriteAPI{}
+			out := &output{influxWriteAPI: influx}
+			conn, err := newConnector(Config{}, out, stateDir)
+			if err != nil {
+				t.Fatalf("newConnector: %s", err)
+			}
+			for _, p := range tc.polls {
+				if p.restart {
+					if conn, err = newConnector(Config{}, out, stateDir); err != nil {
+						t.Fatalf("newConnector: %s", err)
+					}
+				}
+				if err := conn.processOccupancy(p.thermostat(t), occupancyTime(t, p.at)); err != nil {
+					t.Fatalf("processOccupancy at %s: %s", p.at, err)
+				}
+			}
+
+			var sessions []occupancySession
+			for _, p := range influx.byMeasurement("ecobee_occupancy_session") {
+				id, _ := pointTag(p, "sensor_id")
+				minutes, _ := pointField(p, "duration_minutes")
+				sessions = append(sessions, occupancySession{id, p.Time(), minutes.(float64)})
+			}
+			if !reflect.DeepEqual(sessions, tc.sessions) {
+				t.Errorf("sessions = %v; want %v", sessions, tc.sessions)
+			}
+
+			daily := make(map[string]float64)
+			for _, p := range influx.byMeasurement("ecobee_occupancy_daily") {
+				room, _ := pointTag(p, "room")
+				minutes, _ := pointField(p, "occupied_minutes")
+				daily[p.Time().Format("2006-01-02")+" "+room] = minutes.(float64) // later points overwrite earlier ones
+			}
+			if !reflect.DeepEqual(daily, tc.daily) {
+				t.Errorf("daily minutes = %v; want %v", daily, tc.daily)
+			}
+
+			var saved occupancyState
+			if err := loadState(stateDir, occupancyStateFileName, &saved); err != nil {
+				t.Fatalf("loadState: %s", err)
+			}
+			open := make(map[string]string)
+			for id, start := range saved.Open {
+				open[id] = start.Format("2006-01-02 15:04")
+			}
+			if !reflect.DeepEqual(open, tc.open) {
+				t.Errorf("saved open sessions = %v; want %v", open, tc.open)
+			}
+			if last := tc.polls[len(tc.polls)-1].at; saved.LastPoll.Format("2006-01-02 15:04") != last {
+				t.Errorf("saved last poll = %s; want %s", saved.LastPoll, last)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/avast/retry-go"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/influxdata/influxdb-client-go/v2"
+	influxdb2api "github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// output writes points to whichever of InfluxDB and MQTT are enabled.
+type output struct {
+	config         Config
+	influxWriteAPI influxdb2api.WriteAPIBlocking // nil if InfluxDB is disabled
+	influxTimeout  time.Duration
+	mqttClient     mqtt.Client // nil if MQTT is disabled
+}
+
+// write writes a point to InfluxDB and publishes its fields to MQTT under
+// mqttPrefix, retrying failures a few times.
+func (o *output) write(measurement string, tags map[string]string, fields map[string]any, ts time.Time, mqttPrefix string) error {
+	return retry.Do(func() error {
+		if o.influxWriteAPI != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), o.influxTimeout)
+			defer cancel()
+			if err := o.influxWriteAPI.WritePoint(ctx,
+				influxdb2.NewPoint(measurement, tags, fields, ts),
+			); err != nil {
+				return err
+			}
+		}
+
+		if o.mqttClient != nil {
+			if err := publishFieldsToMQTT(o.mqttClient, o.config, mqttPrefix, fields); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, retry.Attempts(3), retry.Delay(1*time.Second))
+}
+
+// publishJSON publishes v, encoded as JSON, to the MQTT topic topicSuffix
+// (under the topic root and thermostat ID), if MQTT is enabled.
+func (o *output) publishJSON(topicSuffix string, v any) error {
+	if o.mqttClient == nil {
+		return nil
+	}
+	return retry.Do(func() error {
+		return publishJSONToMQTT(o.mqttClient, o.config, topicSuffix, v)
+	}, retry.Attempts(3), retry.Delay(1*time.Second))
+}
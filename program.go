@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	wx "github.com/cdzombak/libwx"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// scheduleDays names the days of Program.Schedule, which starts on Monday.
+var scheduleDays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// processProgram writes the current climate, and each climate's set points.
+// A climate's set points are only written when they've changed, or when
+// they were last written more than settingsRewriteInterval ago.
+func (c *connector) processProgram(t *ecobee.Thermostat, at time.Time) error {
+	p := t.Program
+	if len(p.Climates) == 0 {
+		// program wasn't included in the response
+		return nil
+	}
+
+	current := climateByRef(p, p.CurrentClimateRef)
+	fmt.Printf("Current climate at %s: %s (%s)\n", at, current.Name, p.CurrentClimateRef)
+	if err := c.out.write(
+		"ecobee_current_climate",
+		map[string]string{thermostatNameTag: t.Name},
+		map[string]any{
+			"climate_name": current.Name,
+			"climate_ref":  p.CurrentClimateRef,
+		},
+		at,
+		"current_climate",
+	); err != nil {
+		return err
+	}
+
+	rewrite := at.Sub(c.lastWrittenClimatesAt) >= settingsRewriteInterval
+	written := make(map[string]writtenClimate, len(p.Climates))
+	for _, cl := range p.Climates {
+		heat := wx.TempF(float64(cl.HeatTemp) / 10.0)
+		cool := wx.TempF(float64(cl.CoolTemp) / 10.0)
+		w := writtenClimate{
+			name: cl.Name,
+			fields: map[string]any{
+				"heat_set_point_f": heat.Unwrap(),
+				"heat_set_point_c": heat.C().Unwrap(),
+				"cool_set_point_f": cool.Unwrap(),
+				"cool_set_point_c": cool.C().Unwrap(),
+				"heat_fan":         cl.HeatFan,
+				"cool_fan":         cl.CoolFan,
+				"is_occupied":      cl.IsOccupied,
+				"is_current":       cl.ClimateRef == p.CurrentClimateRef,
+			},
+		}
+		written[cl.ClimateRef] = w
+		if last, ok := c.lastWrittenClimates[cl.ClimateRef]; ok && !rewrite && last.equal(w) {
+			continue
+		}
+		if err := c.out.write(
+			"ecobee_climate",
+			map[string]string{
+				thermostatNameTag: t.Name,
+				"climate_name":    cl.Name,
+				"climate_ref":     cl.ClimateRef,
+			},
+			w.fields,
+			at,
+			fmt.Sprintf("climate/%s", cl.ClimateRef),
+		); err != nil {
+			return err
+		}
+	}
+
+	c.lastWrittenClimates = written
+	if rewrite {
+		c.lastWrittenClimatesAt = at
+	}
+	return nil
+}
+
+// writtenClimate is what was last written to ecobee_climate for a climate.
+type writtenClimate struct {
+	name   string
+	fields map[string]any
+}
+
+func (w writtenClimate) equal(o writtenClimate) bool {
+	return w.name == o.name && maps.Equal(w.fields, o.fields)
+}
+
+// climateByRef returns the program's climate with the given ref. If there's
+// no such climate, the returned climate is named after the ref.
+func climateByRef(p ecobee.Program, ref string) ecobee.Climate {
+	for _, cl := range p.Climates {
+		if cl.ClimateRef == ref {
+			return cl
+		}
+	}
+	return ecobee.Climate{Name: ref, ClimateRef: ref}
+}
+
+// schedulePeriod is a run of consecutive schedule slots using one climate.
+type schedulePeriod struct {
+	Start      string `json:"start"` // HH:MM
+	End        string `json:"end"`   // HH:MM; 24:00 is the end of the day
+	Climate    string `json:"climate"`
+	ClimateRef string `json:"climate_ref"`
+}
+
+// scheduleDump is the JSON form of -dump-schedule's output.
+type scheduleDump struct {
+	CurrentClimateRef string                      `json:"current_climate_ref"`
+	Climates          []scheduleClimate           `json:"climates"`
+	Schedule          map[string][]schedulePeriod `json:"schedule"`
+}
+
+type scheduleClimate struct {
+	Name          string  `json:"name"`
+	ClimateRef    string  `json:"climate_ref"`
+	HeatSetPointF float64 `json:"heat_set_point_f"`
+	CoolSetPointF float64 `json:"cool_set_point_f"`
+	IsOccupied    bool    `json:"is_occupied"`
+}
+
+// schedulePeriods collapses a day's schedule slots into periods.
+func schedulePeriods(p ecobee.Program, slots []string) []schedulePeriod {
+	if len(slots) == 0 {
+		return nil
+	}
+	slotLength := 24 * time.Hour / time.Duration(len(slots))
+	clock := func(slot int) string {
+		d := time.Duration(slot) * slotLength
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+
+	var periods []schedulePeriod
+	start := 0
+	for i := 1; i <= len(slots); i++ {
+		if i < len(slots) && slots[i] == slots[start] {
+			continue
+		}
+		periods = append(periods, schedulePeriod{
+			Start:      clock(start),
+			End:        clock(i),
+			Climate:    climateByRef(p, slots[start]).Name,
+			ClimateRef: slots[start],
+		})
+		start = i
+	}
+	return periods
+}
+
+// dumpSchedule writes the program's climates and weekly schedule to w, as
+// either a table or JSON.
+func dumpSchedule(w io.Writer, p ecobee.Program, format string) error {
+	dump := scheduleDump{
+		CurrentClimateRef: p.CurrentClimateRef,
+		Schedule:          make(map[string][]schedulePeriod),
+	}
+	for _, cl := range p.Climates {
+		dump.Climates = append(dump.Climates, scheduleClimate{
+			Name:          cl.Name,
+			ClimateRef:    cl.ClimateRef,
+			HeatSetPointF: float64(cl.HeatTemp) / 10.0,
+			CoolSetPointF: float64(cl.CoolTemp) / 10.0,
+			IsOccupied:    cl.IsOccupied,
+		})
+	}
+	for i, slots := range p.Schedule {
+		if i < len(scheduleDays) {
+			dump.Schedule[strings.ToLower(scheduleDays[i])] = schedulePeriods(p, slots)
+		}
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dump)
+	case "table":
+	default:
+		return fmt.Errorf("unknown schedule format '%s' (expected table or json)", format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIMATE\tREF\tHEAT\tCOOL\tOCCUPIED\t")
+	for _, cl := range dump.Climates {
+		current := ""
+		if cl.ClimateRef == p.CurrentClimateRef {
+			current = "(current)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1f degF\t%.1f degF\t%t\t%s\n",
+			cl.Name, cl.ClimateRef, cl.HeatSetPointF, cl.CoolSetPointF, cl.IsOccupied, current)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DAY\tFROM\tTO\tCLIMATE\t")
+	for i, day := range scheduleDays {
+		if i >= len(p.Schedule) {
+			break
+		}
+		for j, period := range dump.Schedule[strings.ToLower(day)] {
+			label := ""
+			if j == 0 {
+				label = day
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", label, period.Start, period.End, period.Climate)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+const (
+	programBackupDirName = "program-backups"
+	programBackupVersion = 1
+)
+
+// programBackup is the format of a program backup file. Version is
+// incremented if the format changes incompatibly.
+type programBackup struct {
+	Version        int            `json:"version"`
+	SavedAt        time.Time      `json:"saved_at"`
+	ThermostatID   string         `json:"thermostat_id"`
+	ThermostatName string         `json:"thermostat_name"`
+	ThermostatRev  string         `json:"thermostat_rev"`
+	Program        ecobee.Program `json:"program"`
+}
+
+// saveProgramBackup writes the thermostat's program to a new, timestamped
+// backup file in the work directory, returning its path.
+func saveProgramBackup(workDir string, t *ecobee.Thermostat) (string, error) {
+	dir := path.Join(workDir, programBackupDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	b := programBackup{
+		Version:        programBackupVersion,
+		SavedAt:        time.Now().UTC(),
+		ThermostatID:   t.Identifier,
+		ThermostatName: t.Name,
+		ThermostatRev:  t.ThermostatRev,
+		Program:        t.Program,
+	}
+	j, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	p := path.Join(dir, fmt.Sprintf("program-%s-%s.json", t.Identifier, b.SavedAt.Format("20060102T150405Z")))
+	if err := os.WriteFile(p, j, 0600); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// loadProgramBackup reads a program backup file.
+func loadProgramBackup(p string) (*programBackup, error) {
+	j, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var b programBackup
+	if err := json.Unmarshal(j, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse program backup '%s': %w", p, err)
+	}
+	if b.Version != programBackupVersion {
+		return nil, fmt.Errorf("program backup '%s' has unsupported version %d", p, b.Version)
+	}
+	if len(b.Program.Schedule) == 0 || len(b.Program.Climates) == 0 {
+		return nil, fmt.Errorf("program backup '%s' has no schedule or climates", p)
+	}
+	return &b, nil
+}
+
+// configChange is a single difference between two versions of a
+// thermostat's configuration. Old is empty if the field was added, and New
+// is empty if it was removed.
+type configChange struct {
+	Field    string
+	Old, New string
+}
+
+func (c configChange) String() string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("+ %s: %s", c.Field, c.New)
+	case c.New == "":
+		return fmt.Sprintf("- %s: %s", c.Field, c.Old)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+func formatChanges(changes []configChange) string {
+	lines := make([]string, len(changes))
+	for i, c := range changes {
+		lines[i] = c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// diffPrograms describes the changes needed to turn program from into
+// program to. Climates are matched by climateRef, and their sensors by ID.
+// The current climate is ignored, since it changes with the time of day.
+func diffPrograms(from, to ecobee.Program) []configChange {
+	var diff []configChange
+
+	fromClimates := make(map[string]ecobee.Climate)
+	for _, cl := range from.Climates {
+		fromClimates[cl.ClimateRef] = cl
+	}
+	toClimates := make(map[string]ecobee.Climate)
+	for _, cl := range to.Climates {
+		toClimates[cl.ClimateRef] = cl
+	}
+	for _, cl := range from.Climates {
+		if _, ok := toClimates[cl.ClimateRef]; !ok {
+			diff = append(diff, configChange{Field: "climate." + cl.ClimateRef, Old: cl.Name})
+		}
+	}
+	for _, cl := range to.Climates {
+		old, ok := fromClimates[cl.ClimateRef]
+		if !ok {
+			diff = append(diff, configChange{Field: "climate." + cl.ClimateRef, New: cl.Name})
+			continue
+		}
+		diff = append(diff, diffClimates(old, cl)...)
+	}
+
+	for day := 0; day < max(len(from.Schedule), len(to.Schedule)); day++ {
+		var fromSlots, toSlots []string
+		if day < len(from.Schedule) {
+			fromSlots = from.Schedule[day]
+		}
+		if day < len(to.Schedule) {
+			toSlots = to.Schedule[day]
+		}
+		diff = append(diff, diffScheduleDay(day, fromSlots, toSlots)...)
+	}
+	return diff
+}
+
+// diffClimates compares two versions of a climate, field by field.
+func diffClimates(from, to ecobee.Climate) []configChange {
+	prefix := "climate." + to.ClimateRef + "."
+	fromSensorList, toSensorList := from.Sensors, to.Sensors
+	from.Sensors, to.Sensors = nil, nil // compared separately below
+	diff := diffFields(prefix, flattenJSON(from), flattenJSON(to))
+
+	fromSensors := make(map[string]string)
+	for _, s := range fromSensorList {
+		fromSensors[s.ID] = s.Name
+	}
+	toSensors := make(map[string]string)
+	for _, s := range toSensorList {
+		toSensors[s.ID] = s.Name
+	}
+	for _, s := range fromSensorList {
+		if _, ok := toSensors[s.ID]; !ok {
+			diff = append(diff, configChange{Field: prefix + "sensor." + s.ID, Old: s.Name})
+		}
+	}
+	for _, s := range toSensorList {
+		if _, ok := fromSensors[s.ID]; !ok {
+			diff = append(diff, configChange{Field: prefix + "sensor." + s.ID, New: s.Name})
+		}
+	}
+	return diff
+}
+
+// diffFields compares two flattened objects (see flattenJSON), in order of
+// field name.
+func diffFields(prefix string, from, to map[string]string) []configChange {
+	var keys []string
+	for k := range to {
+		keys = append(keys, k)
+	}
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var diff []configChange
+	for _, k := range keys {
+		if from[k] != to[k] {
+			diff = append(diff, configChange{Field: prefix + k, Old: from[k], New: to[k]})
+		}
+	}
+	return diff
+}
+
+// flattenJSON flattens the JSON representation of v into a map from dotted
+// field paths to JSON-encoded values.
+func flattenJSON(v any) map[string]string {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var generic any
+	if err := json.Unmarshal(j, &generic); err != nil {
+		return nil
+	}
+	out := make(map[string]string)
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, child := range v {
+				walk(strings.TrimPrefix(prefix+"."+k, "."), child)
+			}
+		case []any:
+			for i, child := range v {
+				walk(fmt.Sprintf("%s[%d]", prefix, i), child)
+			}
+		default:
+			j, _ := json.Marshal(v)
+			out[prefix] = string(j)
+		}
+	}
+	walk("", generic)
+	return out
+}
+
+// diffScheduleDay compares a day's schedule slots, collapsing consecutive
+// slots with the same change into one line.
+func diffScheduleDay(day int, from, to []string) []configChange {
+	dayName := fmt.Sprintf("day %d", day)
+	if day < len(scheduleDays) {
+		dayName = scheduleDays[day]
+	}
+	slots := max(len(from), len(to))
+	if slots == 0 {
+		return nil
+	}
+	slotLength := 24 * time.Hour / time.Duration(slots)
+	clock := func(slot int) string {
+		d := time.Duration(slot) * slotLength
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	at := func(s []string, i int) string {
+		if i < len(s) {
+			return s[i]
+		}
+		return "(none)"
+	}
+
+	var diff []configChange
+	start := -1
+	for i := 0; i <= slots; i++ {
+		if start >= 0 && (i == slots || at(from, i) != at(from, start) || at(to, i) != at(to, start)) {
+			diff = append(diff, configChange{
+				Field: fmt.Sprintf("schedule.%s.%s-%s", dayName, clock(start), clock(i)),
+				Old:   at(from, start),
+				New:   at(to, start),
+			})
+			start = -1
+		}
+		if i < slots && start < 0 && at(from, i) != at(to, i) {
+			start = i
+		}
+	}
+	return diff
+}
+
+// confirm asks the user a yes/no question on stdin, returning true if they
+// answer yes.
+func confirm(in io.Reader, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// runProgramCommand carries out -backup-program, -diff-program or
+// -restore-program against the live thermostat. A backup of a different
+// thermostat is only restored if force is true.
+func runProgramCommand(client *ecobee.Client, config Config, backup bool, diffFile, restoreFile string, dryRun, yes, force bool) error {
+	t, err := client.GetThermostat(config.ThermostatID)
+	if err != nil {
+		return err
+	}
+
+	if backup {
+		p, err := saveProgramBackup(config.WorkDir, t)
+		if err != nil {
+			return fmt.Errorf("failed to save program backup: %w", err)
+		}
+		fmt.Printf("Saved program backup to %s\n", p)
+		return nil
+	}
+
+	file := diffFile
+	if restoreFile != "" {
+		file = restoreFile
+	}
+	b, err := loadProgramBackup(file)
+	if err != nil {
+		return err
+	}
+	if b.ThermostatID != t.Identifier {
+		if restoreFile != "" && !force {
+			return fmt.Errorf("backup is of thermostat %s, not %s; use -force to restore it anyway", b.ThermostatID, t.Identifier)
+		}
+		fmt.Printf("Warning: backup is of thermostat %s, not %s.\n", b.ThermostatID, t.Identifier)
+	}
+
+	if restoreFile == "" {
+		// show changes made since the backup:
+		diff := diffPrograms(b.Program, t.Program)
+		if len(diff) == 0 {
+			fmt.Printf("Live program matches the backup saved at %s.\n", b.SavedAt.Local().Format(time.RFC1123))
+			return nil
+		}
+		fmt.Printf("Changes since the backup saved at %s:\n", b.SavedAt.Local().Format(time.RFC1123))
+		fmt.Println(formatChanges(diff))
+		return nil
+	}
+
+	diff := diffPrograms(t.Program, b.Program)
+	if len(diff) == 0 {
+		fmt.Println("Live program already matches the backup; nothing to restore.")
+		return nil
+	}
+	fmt.Printf("Restoring the backup saved at %s will make these changes:\n", b.SavedAt.Local().Format(time.RFC1123))
+	fmt.Println(formatChanges(diff))
+	if dryRun {
+		fmt.Println("Dry run; no changes made.")
+		return nil
+	}
+	if !yes && !confirm(os.Stdin, "Restore this program?") {
+		fmt.Println("Not restoring.")
+		return nil
+	}
+
+	// Keep a copy of the program being replaced, in case the restore was a mistake:
+	p, err := saveProgramBackup(config.WorkDir, t)
+	if err != nil {
+		return fmt.Errorf("failed to back up the live program before restoring: %w", err)
+	}
+	fmt.Printf("Saved the current program to %s\n", p)
+	if err := client.UpdateProgram(config.ThermostatID, b.Program); err != nil {
+		return fmt.Errorf("failed to restore program: %w", err)
+	}
+	fmt.Println("Program restored.")
+	return nil
+}
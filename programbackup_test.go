@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+func TestDiffPrograms(t *testing.T) {
+	bedroom := ecobee.RemoteSensor{ID: "rs:100:1", Name: "Bedroom"}
+	office := ecobee.RemoteSensor{ID: "rs:101:1", Name: "Office"}
+	from := ecobee.Program{
+		Schedule: [][]string{
+			{"sleep", "home", "home", "sleep"},
+			{"sleep", "away", "away", "sleep"},
+		},
+		Climates: []ecobee.Climate{
+			{Name: "Home", ClimateRef: "home", HeatTemp: 700, CoolTemp: 760, Sensors: []ecobee.RemoteSensor{bedroom, office}},
+			{Name: "Away", ClimateRef: "away", HeatTemp: 620, CoolTemp: 820},
+			{Name: "Sleep", ClimateRef: "sleep", HeatTemp: 660, CoolTemp: 740, Sensors: []ecobee.RemoteSensor{bedroom, office}},
+		},
+		CurrentClimateRef: "home",
+	}
+	to := ecobee.Program{
+		Schedule: [][]string{
+			{"sleep", "home", "home", "sleep"},
+			{"sleep", "work", "work", "sleep"},
+		},
+		Climates: []ecobee.Climate{
+			{Name: "Home", ClimateRef: "home", HeatTemp: 680, CoolTemp: 760, Sensors: []ecobee.RemoteSensor{bedroom, office}},
+			{Name: "Sleep", ClimateRef: "sleep", HeatTemp: 660, CoolTemp: 740, Sensors: []ecobee.RemoteSensor{bedroom}},
+			{Name: "Work", ClimateRef: "work", HeatTemp: 640, CoolTemp: 800},
+		},
+		CurrentClimateRef: "sleep",
+	}
+
+	want := []configChange{
+		{Field: "climate.away", Old: "Away"},
+		{Field: "climate.home.heatTemp", Old: "700", New: "680"},
+		{Field: "climate.sleep.sensor.rs:101:1", Old: "Office"},
+		{Field: "climate.work", New: "Work"},
+		{Field: "schedule.Tuesday.06:00-18:00", Old: "away", New: "work"},
+	}
+	if got := diffPrograms(from, to); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffPrograms =\n%s\nwant\n%s", formatChanges(got), formatChanges(want))
+	}
+
+	if got := diffPrograms(from, from); len(got) != 0 {
+		t.Errorf("diffPrograms of identical programs =\n%s\nwant no changes", formatChanges(got))
+	}
+}
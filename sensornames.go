@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// sensorName returns the name to show and publish the sensor's data under:
+// its alias from the config file if it has one, or else its name.
+func (c *connector) sensorName(sensor ecobee.RemoteSensor) string {
+	if sc, ok := c.config.Sensors[sensor.ID]; ok && sc.Alias != "" {
+		return sc.Alias
+	}
+	return sensor.Name
+}
+
+// sensorTags adds the tags identifying the sensor to tags: its ID, and the
+// alias, room, floor and zone configured for it. Only values which don't
+// change when the sensor is renamed in the Ecobee app are used, so a rename
+// doesn't start a new series; the Ecobee name is written as a field instead.
+func (c *connector) sensorTags(sensor ecobee.RemoteSensor, tags map[string]string) {
+	sc := c.config.Sensors[sensor.ID]
+	tags["sensor_id"] = sensor.ID
+	for k, v := range map[string]string{"sensor_alias": sc.Alias, "room": sc.Room, "floor": sc.Floor, "zone": sc.Zone} {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+}
+
+// sensorTopic returns the MQTT category to publish the sensor's data under:
+// sensor/[<zone>/][<floor>/][<room>/]<name>, with segments which aren't
+// configured for the sensor left out.
+func (c *connector) sensorTopic(sensor ecobee.RemoteSensor) string {
+	sc := c.config.Sensors[sensor.ID]
+	segments := []string{"sensor"}
+	for _, v := range []string{sc.Zone, sc.Floor, sc.Room} {
+		if v != "" {
+			segments = append(segments, v)
+		}
+	}
+	return strings.Join(append(segments, c.sensorName(sensor)), "/")
+}
+
+// findSensor returns the thermostat's sensor with the given ID or
+// (case-insensitive) name.
+func findSensor(t *ecobee.Thermostat, want string) (ecobee.RemoteSensor, error) {
+	want = strings.TrimSpace(want)
+	for _, sensor := range t.RemoteSensors {
+		if sensor.ID == want {
+			return sensor, nil
+		}
+	}
+	for _, sensor := range t.RemoteSensors {
+		if strings.EqualFold(sensor.Name, want) {
+			return sensor, nil
+		}
+	}
+	return ecobee.RemoteSensor{}, fmt.Errorf("no sensor named or with ID '%s'", want)
+}
+
+// temperatureCapabilityID returns the ID of the sensor's temperature
+// capability, which Ecobee uses to refer to the sensor as a whole.
+func temperatureCapabilityID(sensor ecobee.RemoteSensor) string {
+	for _, c := range sensor.Capability {
+		if c.Type == "temperature" {
+			return c.ID
+		}
+	}
+	return "1"
+}
+
+// renameSensor renames the sensor with the given ID or name. It returns the
+// sensor's previous name.
+func renameSensor(client *ecobee.Client, thermostatID, sensor, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("the sensor's new name must not be empty")
+	}
+	t, err := client.GetThermostat(thermostatID)
+	if err != nil {
+		return "", err
+	}
+	s, err := findSensor(t, sensor)
+	if err != nil {
+		return "", err
+	}
+	if s.Name == name {
+		return s.Name, nil
+	}
+	if err := client.UpdateSensor(thermostatID, s.ID, temperatureCapabilityID(s), name); err != nil {
+		return "", fmt.Errorf("failed to rename sensor '%s': %w", s.Name, err)
+	}
+	return s.Name, nil
+}
+
+// renameSensorCommand is the payload of the rename_sensor command.
+type renameSensorCommand struct {
+	Sensor string `json:"sensor"`
+	Name   string `json:"name"`
+}
+
+func renameSensorHandler(client *ecobee.Client, thermostatID string) commandHandler {
+	return func(payload []byte) error {
+		var cmd renameSensorCommand
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			return fmt.Errorf("invalid rename_sensor command: %w", err)
+		}
+		_, err := renameSensor(client, thermostatID, cmd.Sensor, cmd.Name)
+		return err
+	}
+}
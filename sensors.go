@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	wx "github.com/cdzombak/libwx"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// sensorFields converts a sensor's capabilities into fields. Capabilities
+// whose value is "unknown" (e.g. a sensor which has lost contact with the
+// thermostat) are omitted, so they can be told apart from real zero values.
+//
+// Temperatures are converted from tenths of a degree F, and occupancy is
+// written as the boolean field "occupied". Other capabilities are written
+// under the snake_case form of their type (e.g. airPressure becomes
+// air_pressure). InfluxDB requires a field's type never to change, so
+// capabilities known to be integers are written as integers, and any other
+// numeric value always as a float.
+func sensorFields(sensor ecobee.RemoteSensor) map[string]any {
+	fields := make(map[string]any)
+	for _, c := range sensor.Capability {
+		if c.Value == "" || c.Value == "unknown" {
+			continue
+		}
+		switch c.Type {
+		case "temperature":
+			tempInt, err := strconv.Atoi(c.Value)
+			if err != nil {
+				log.Printf("error reading temp '%s' for sensor %s: %s", c.Value, sensor.Name, err)
+				continue
+			}
+			temp := wx.TempF(float64(tempInt) / 10.0)
+			fields["temperature"] = temp.Unwrap()
+			fields["temperature_f"] = temp.Unwrap()
+			fields["temperature_c"] = temp.C().Unwrap()
+		case "occupancy":
+			fields["occupied"] = c.Value == "true"
+		default:
+			name := snakeCase(c.Type)
+			if integerCapabilities[c.Type] {
+				i, err := strconv.Atoi(c.Value)
+				if err != nil {
+					log.Printf("error reading %s '%s' for sensor %s: %s", c.Type, c.Value, sensor.Name, err)
+					continue
+				}
+				fields[name] = i
+			} else if f, err := strconv.ParseFloat(c.Value, 64); err == nil {
+				fields[name] = f
+			} else if c.Value == "true" || c.Value == "false" {
+				fields[name] = c.Value == "true"
+			} else {
+				fields[name] = c.Value
+			}
+		}
+	}
+	return fields
+}
+
+// integerCapabilities are the sensor capabilities whose values Ecobee reports
+// as integers.
+var integerCapabilities = map[string]bool{
+	"humidity":           true,
+	"airPressure":        true,
+	"co2PPM":             true,
+	"vocPPM":             true,
+	"airQuality":         true,
+	"airQualityAccuracy": true,
+}
+
+// snakeCase converts a camelCase identifier, such as vocPPM, to snake_case
+// (voc_ppm).
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
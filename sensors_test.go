@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"temperature", "temperature"},
+		{"airPressure", "air_pressure"},
+		{"vocPPM", "voc_ppm"},
+		{"co2PPM", "co2_ppm"},
+		{"airQualityAccuracy", "air_quality_accuracy"},
+		{"PPMValue", "ppm_value"},
+		{"", ""},
+	} {
+		if got := snakeCase(tc.in); got != tc.want {
+			t.Errorf("snakeCase(%q) = %q; want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSensorFields(t *testing.T) {
+	sensor := ecobee.RemoteSensor{
+		ID:   "rs:100",
+		Name: "Bedroom",
+		Capability: []ecobee.RemoteSensorCapability{
+			{ID: "1", Type: "temperature", Value: "705"},
+			{ID: "2", Type: "occupancy", Value: "true"},
+			{ID: "3", Type: "humidity", Value: "41"},
+			{ID: "4", Type: "airPressure", Value: "unknown"},
+			{ID: "5", Type: "vocPPM", Value: ""},
+			{ID: "6", Type: "co2PPM", Value: "not a number"},
+			{ID: "7", Type: "batteryVoltage", Value: "3"},
+			{ID: "8", Type: "dryContact", Value: "false"},
+			{ID: "9", Type: "mode", Value: "eco"},
+		},
+	}
+	got := sensorFields(sensor)
+
+	temp, ok := got["temperature_c"].(float64)
+	if !ok || temp < 21.38 || temp > 21.40 {
+		t.Errorf("temperature_c = %v; want about 21.39", got["temperature_c"])
+	}
+	delete(got, "temperature_c")
+
+	want := map[string]any{
+		"temperature":     70.5,
+		"temperature_f":   70.5,
+		"occupied":        true,
+		"humidity":        41,
+		"battery_voltage": 3.0,
+		"dry_contact":     false,
+		"mode":            "eco",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sensorFields = %#v; want %#v", got, want)
+	}
+}
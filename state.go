@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+// loadState reads the JSON state file with the given name from the work
+// directory into v. If the file doesn't exist, v is left unchanged.
+func loadState(workDir, name string, v any) error {
+	p := path.Join(workDir, name)
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read state from '%s': %w", p, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to parse state from '%s': %w", p, err)
+	}
+	return nil
+}
+
+// saveState atomically writes v, as JSON, to the state file with the given
+// name in the work directory.
+func saveState(workDir, name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	p := path.Join(workDir, name)
+	tmp, err := os.CreateTemp(workDir, "."+name+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write state to '%s': %w", p, err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write state to '%s': %w", p, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state to '%s': %w", p, err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("failed to write state to '%s': %w", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	wx "github.com/cdzombak/libwx"
+
+	"ecobee_influx_connector/ecobee"
+)
+
+// zoneReading accumulates the readings of the sensors in a zone.
+type zoneReading struct {
+	tempSum  float64
+	count    int
+	occupied bool
+}
+
+// processZones writes the average temperature of the sensors in each zone
+// configured in the sensors section of the config file, to the ecobee_zone
+// measurement. Sensors without a temperature reading are left out of the
+// average.
+func (c *connector) processZones(t *ecobee.Thermostat, ts time.Time) error {
+	zones := make(map[string]*zoneReading)
+	for _, sensor := range t.RemoteSensors {
+		zone := c.config.Sensors[sensor.ID].Zone
+		if zone == "" {
+			continue
+		}
+		fields := sensorFields(sensor)
+		temp, ok := fields["temperature_f"].(float64)
+		if !ok {
+			continue
+		}
+		z, ok := zones[zone]
+		if !ok {
+			z = &zoneReading{}
+			zones[zone] = z
+		}
+		z.tempSum += temp
+		z.count++
+		if occupied, _ := fields["occupied"].(bool); occupied {
+			z.occupied = true
+		}
+	}
+
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		z := zones[name]
+		avg := wx.TempF(z.tempSum / float64(z.count))
+		fields := map[string]any{
+			"temperature":   avg.Unwrap(),
+			"temperature_f": avg.Unwrap(),
+			"temperature_c": avg.C().Unwrap(),
+			"sensor_count":  z.count,
+			"occupied":      z.occupied,
+		}
+		fmt.Printf("Zone '%s' at %s: %.1f F from %d sensor(s)\n", name, ts, avg.Unwrap(), z.count)
+		if err := c.out.write(
+			"ecobee_zone",
+			map[string]string{
+				thermostatNameTag: t.Name,
+				"zone":            name,
+			},
+			fields,
+			ts,
+			fmt.Sprintf("zone/%s", name),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}